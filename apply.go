@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cego/zfs-cleaner/conf"
+	"github.com/cego/zfs-cleaner/zfs"
+	"github.com/spf13/cobra"
+)
+
+func AddPlanCommand(zfsExecutor zfs.Executor) {
+	outputPath := ""
+	planCmd := &cobra.Command{
+		Use:   "plan -o [plan file] [config file]",
+		Short: "Write the snapshots that would be destroyed to a plan file and exit",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 || outputPath == "" {
+				return fmt.Errorf("%s -o plan.json /path/to/config.conf", cmd.Name())
+			}
+			configPath, err := filepath.Abs(args[0])
+			if err != nil {
+				return err
+			}
+			config, unlock, err := lockConfig(configPath)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+			if err := zfsExecutor.HasZFSCommand(); err != nil {
+				return err
+			}
+			lists, err := processAll(now, config, zfsExecutor)
+			if err != nil {
+				return err
+			}
+			allowed := planned(config, lists)
+			m, err := newManifest(configPath, allowed, zfsExecutor)
+			if err != nil {
+				return err
+			}
+			for _, snapshot := range m.Snapshots {
+				_ = newComment("Plan to destroy %s (Age %s)", snapshot.Name, now.Sub(snapshot.Creation)).Do()
+			}
+			f, err := os.Create(outputPath)
+			if err != nil {
+				return err
+			}
+			err = m.write(f)
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("failed to write %s: %s", outputPath, err.Error())
+			}
+			err = f.Close()
+			if err != nil {
+				return fmt.Errorf("failed to write %s: %s", outputPath, err.Error())
+			}
+			info("Wrote %d snapshot(s) to destroy to '%s'", len(m.Snapshots), outputPath)
+			if len(allowed) < len(lists) {
+				return fmt.Errorf("refused to clean %d dataset(s), these were left out of the plan", len(lists)-len(allowed))
+			}
+			return nil
+		},
+	}
+	planCmd.Flags().StringVarP(&outputPath, "out", "o", "", "Path to write the plan to")
+	rootCmd.AddCommand(planCmd)
+}
+
+// planned returns the lists of datasets to include in a plan. Datasets
+// that failed or are refused by checkDataset are left out, and reported.
+func planned(config *conf.Config, lists []datasetList) []datasetList {
+	allowed := []datasetList{}
+	for _, list := range lists {
+		if list.err != nil {
+			// Already reported by processAll.
+			continue
+		}
+		if err := checkDataset(config, list); err != nil {
+			reportError(list.dataset, err)
+			continue
+		}
+		allowed = append(allowed, list)
+	}
+	return allowed
+}
+
+func AddApplyCommand(zfsExecutor zfs.Executor) {
+	applyCmd := &cobra.Command{
+		Use:   "apply [plan file]",
+		Short: "Destroy the snapshots listed in a plan file written by plan",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("%s /path/to/plan.json", cmd.Name())
+			}
+			return apply(zfsExecutor, args[0])
+		},
+	}
+	rootCmd.AddCommand(applyCmd)
+}
+
+// apply will destroy the snapshots listed in the plan file at path, but only
+// if every snapshot still exists with the GUID recorded in the plan. The lock
+// on the configuration the plan was made from is held while applying, and its
+// retry policy is used.
+func apply(zfsExecutor zfs.Executor, path string) error {
+	planFile, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", path, err.Error())
+	}
+	m, err := readManifest(planFile)
+	planFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %s", path, err.Error())
+	}
+	config, unlock, err := lockConfig(m.Config)
+	if err != nil {
+		return err
+	}
+	// make sure to unlock :)
+	defer unlock()
+	if err := zfsExecutor.HasZFSCommand(); err != nil {
+		return err
+	}
+	err = m.verify(zfsExecutor)
+	if err != nil {
+		return err
+	}
+	todos := []todo{}
+	if verbose {
+		todos = append(todos, newComment("Plan: '%s' (created %s from '%s')", path, m.Created, m.Config))
+	}
+	snapshots := zfs.SnapshotList{}
+	for _, snapshot := range m.Snapshots {
+		s := &zfs.Snapshot{
+			Name:     snapshot.Name,
+			Creation: snapshot.Creation,
+			GUID:     snapshot.GUID,
+		}
+		todos = append(todos, newComment("Destroying %s (Age %s)", s.Name, now.Sub(s.Creation)))
+		snapshots = append(snapshots, s)
+	}
+	retry := newRetryPolicy(config)
+	batches := []*destroyBatch{}
+	for _, batch := range snapshots.Batches(batchSize) {
+		d := newDestroyBatch(zfsExecutor, "", batch)
+		d.retry = retry
+		batches = append(batches, d)
+		todos = append(todos, d)
+	}
+	for _, todo := range todos {
+		err = todo.Do()
+		if err != nil {
+			break
+		}
+	}
+	reportRetries([][]*destroyBatch{batches})
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, batch := range batches {
+		failed += batch.failed
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to destroy %d snapshot(s) from the plan", failed)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+)
+
+func writePlanFile(t *testing.T, m *manifest) string {
+	tmpfile, err := ioutil.TempFile("/dev/shm", "test.plan")
+	if err != nil {
+		t.Fatalf("Failed to create plan file: %s", err.Error())
+	}
+	defer tmpfile.Close()
+
+	err = m.write(tmpfile)
+	if err != nil {
+		t.Fatalf("Failed to write plan file: %s", err.Error())
+	}
+
+	return tmpfile.Name()
+}
+
+func TestPlanApply(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570	0	1001
+playground/fs1@snap2	1492989572	0	1002
+playground/fs1@snap3	1492989587	0	1003
+`),
+	}
+
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{
+				Name:   "buh",
+				Paths:  []string{"playground/fs1"},
+				Latest: 1,
+			},
+		},
+	}
+
+	lists, err := processAll(now, config, zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+
+	configFile, err := ioutil.TempFile("/dev/shm", "test.conf")
+	if err != nil {
+		t.Fatalf("Failed to create config file: %s", err.Error())
+	}
+	defer os.Remove(configFile.Name())
+	configFile.Close()
+
+	m, err := newManifest(configFile.Name(), lists, zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("newManifest() returned error: %s", err.Error())
+	}
+
+	expected := []manifestSnapshot{
+		{Name: "playground/fs1@snap1", GUID: "1001", Creation: time.Unix(1492989570, 0)},
+		{Name: "playground/fs1@snap2", GUID: "1002", Creation: time.Unix(1492989572, 0)},
+	}
+	if !reflect.DeepEqual(m.Snapshots, expected) {
+		t.Fatalf("newManifest() returned wrong snapshots: %+v", m.Snapshots)
+	}
+
+	path := writePlanFile(t, m)
+	defer os.Remove(path)
+
+	// Recreate snap2 and destroy snap1 behind our back. Nothing must be
+	// destroyed.
+	listed := zfsTestExecutor.getSnapshotListResult
+	zfsTestExecutor.getSnapshotListResult = []byte(`playground/fs1@snap2	1492989572	0	2002
+playground/fs1@snap3	1492989587	0	1003
+`)
+	err = apply(zfsTestExecutor, path)
+	if err == nil {
+		t.Fatalf("apply() did not err on stale plan")
+	}
+	for _, problem := range []string{"playground/fs1@snap1: snapshot does not exist", "playground/fs1@snap2: guid is 2002, expected 1002"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("apply() did not report '%s': %s", problem, err.Error())
+		}
+	}
+	if len(zfsTestExecutor.destroyed) != 0 {
+		t.Fatalf("apply() destroyed snapshots from a stale plan: %v", zfsTestExecutor.destroyed)
+	}
+
+	zfsTestExecutor.getSnapshotListResult = listed
+
+	// Nothing must be destroyed while clean holds the lock.
+	_, unlock, err := lockConfig(configFile.Name())
+	if err != nil {
+		t.Fatalf("lockConfig() returned error: %s", err.Error())
+	}
+	err = apply(zfsTestExecutor, path)
+	unlock()
+	if err == nil || len(zfsTestExecutor.destroyed) != 0 {
+		t.Fatalf("apply() did not respect the configuration lock")
+	}
+
+	err = apply(zfsTestExecutor, path)
+	if err != nil {
+		t.Fatalf("apply() returned error: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(zfsTestExecutor.destroyed, []string{"playground/fs1@snap1", "playground/fs1@snap2"}) {
+		t.Fatalf("apply() destroyed wrong snapshots: %v", zfsTestExecutor.destroyed)
+	}
+
+	if !reflect.DeepEqual(zfsTestExecutor.batches, []string{"playground/fs1@snap1,snap2"}) {
+		t.Fatalf("apply() did not destroy in batches: %v", zfsTestExecutor.batches)
+	}
+}
+
+func TestPlanned(t *testing.T) {
+	plan := conf.Plan{Name: "buh", Paths: []string{"playground/fs1", "playground/fs2"}, Latest: 1}
+	lists := []datasetList{
+		{plan: plan, dataset: "playground/fs1", anchor: now},
+		{plan: plan, dataset: "playground/fs2", anchor: now, err: errors.New("failed")},
+	}
+
+	allowed := planned(&conf.Config{Plans: []conf.Plan{plan}}, lists)
+	if len(allowed) != 1 || allowed[0].dataset != "playground/fs1" {
+		t.Fatalf("planned() returned %+v, expected only playground/fs1", allowed)
+	}
+}
+
+func TestApplyBrokenPlan(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("/dev/shm", "test.plan")
+	if err != nil {
+		t.Fatalf("Failed to create plan file: %s", err.Error())
+	}
+	defer os.Remove(tmpfile.Name())
+
+	_, _ = tmpfile.Write([]byte(`{"version": 42, "snapshots": []}`))
+	tmpfile.Close()
+
+	err = apply(&testExecutor{}, tmpfile.Name())
+	if err == nil {
+		t.Fatalf("apply() did not err on unsupported plan version")
+	}
+
+	err = apply(&testExecutor{}, "/non/existing/plan.json")
+	if err == nil {
+		t.Fatalf("apply() did not err on non-existing plan")
+	}
+}
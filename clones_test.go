@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+)
+
+func TestCleanPlansClones(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	output := []byte(`playground/fs1@snap1	1492989570	0	1	0	playground/ci/1
+playground/fs1@snap2	1492989571	0	2	0	playground/ci/2,playground/data
+playground/fs1@snap3	1492989572	0	3	0	-
+playground/fs1@snap4	1492989573	0	4	0	-
+`)
+	allowlist := []conf.Pattern{{Kind: conf.PatternGlob, Expr: "playground/ci/*"}}
+
+	cases := []struct {
+		clones    string
+		err       bool
+		destroyed []string
+		recursive []string
+		notice    string
+	}{
+		{"", false, []string{"playground/fs1@snap3"}, nil, "Keeping playground/fs1@snap1, it has dependent clones playground/ci/1"},
+		{conf.ClonesError, true, nil, nil, ""},
+		{conf.ClonesDestroy, false, []string{"playground/fs1@snap3"}, []string{"playground/fs1@snap1"}, "Keeping playground/fs1@snap2, it has dependent clones playground/ci/2, playground/data"},
+	}
+
+	for i, c := range cases {
+		zfsTestExecutor := &testExecutor{
+			getSnapshotListResult: output,
+		}
+		plan := conf.Plan{
+			Name:   "buh",
+			Paths:  []string{"playground/fs1"},
+			Latest: 1,
+			Clones: c.clones,
+		}
+		if c.clones == conf.ClonesDestroy {
+			plan.CloneAllowlist = allowlist
+		}
+
+		withOutput(outputText, func(buffer *bytes.Buffer) {
+			err := cleanPlans(zfsTestExecutor, "test.conf", &conf.Config{Plans: []conf.Plan{plan}})
+			if (err != nil) != c.err {
+				t.Fatalf("%d cleanPlans() returned %v", i, err)
+			}
+
+			if !reflect.DeepEqual(zfsTestExecutor.destroyed, c.destroyed) {
+				t.Errorf("%d cleanPlans() destroyed %v, expected %v", i, zfsTestExecutor.destroyed, c.destroyed)
+			}
+
+			if !reflect.DeepEqual(zfsTestExecutor.recursive, c.recursive) {
+				t.Errorf("%d cleanPlans() destroyed %v with clones, expected %v", i, zfsTestExecutor.recursive, c.recursive)
+			}
+
+			if c.notice != "" && !strings.Contains(buffer.String(), c.notice) {
+				t.Errorf("%d cleanPlans() did not print '%s':\n%s", i, c.notice, buffer.String())
+			}
+		})
+	}
+}
+
+func TestProcessAllClonesReason(t *testing.T) {
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570	0	1	0	playground/ci/1
+playground/fs1@snap2	1492989571	0	2	0	-
+`),
+	}
+	config := &conf.Config{
+		Plans: []conf.Plan{{Name: "buh", Paths: []string{"playground/fs1"}, Latest: 1}},
+	}
+
+	lists, err := processAll(time.Unix(1492993419, 0), config, zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+
+	snapshot := lists[0].snapshots[0]
+	if !snapshot.Keep || len(snapshot.Reasons) != 1 || snapshot.Reasons[0].String() != "clones: has clones playground/ci/1" {
+		t.Fatalf("processAll() did not keep snapshot with clones: %v", snapshot.Reasons)
+	}
+}
@@ -0,0 +1,106 @@
+package conf
+
+import (
+	"fmt"
+)
+
+// Class is a retention class inside a plan. Snapshots matching the class are
+// sieved using the keep rules of the class instead of the keep rules of the
+// plan.
+type Class struct {
+	Name     string
+	Match    Pattern
+	Latest   int
+	Periods  []Period
+	Calendar []CalendarPeriod
+
+	plan *Plan
+}
+
+const (
+	ErrClassNoKeeps = Error("no keep rules defined in class")
+)
+
+// class parses "class NAME match PATTERN {" and the single line form
+// "class NAME match PATTERN { keep latest 24 }".
+func (p *Plan) class(s *state) action {
+	if len(s.fields) < 5 || s.fields[2] != matchIdentifier || s.fields[4] != blockStart {
+		return s.error(ErrSyntaxError)
+	}
+
+	pattern, err := parseMatch(s.fields[3])
+	if err != nil {
+		return s.error(fmt.Errorf("%s: %s", err.Error(), s.fields[3]))
+	}
+
+	c := &Class{
+		Name:  s.fields[1],
+		Match: pattern,
+		plan:  p,
+	}
+
+	if len(s.fields) == 5 {
+		return c.classLine
+	}
+
+	// Single line form. Parse the contained directive, then end the class.
+	if s.fields[len(s.fields)-1] != blockEnd || len(s.fields) < 7 {
+		return s.error(ErrSyntaxError)
+	}
+
+	s.fields = s.fields[5 : len(s.fields)-1]
+
+	next := c.directive(s)
+	if next == nil || next(s) == nil {
+		return nil
+	}
+
+	return c.end(s)
+}
+
+func (c *Class) classLine(s *state) action {
+	if !s.scanLine() {
+		return s.error(ErrUnterminatedPlan)
+	}
+
+	if len(s.fields) == 1 && s.fields[0] == blockEnd {
+		return c.end
+	}
+
+	return c.directive(s)
+}
+
+// directive returns the action for parsing the directive in s.fields.
+func (c *Class) directive(s *state) action {
+	if len(s.fields) == 4 && s.fields[0] == keepIdentifier && s.fields[2] == keepFor {
+		return func(s *state) action {
+			return parsePeriod(s, &c.Periods, c.classLine)
+		}
+	}
+
+	if len(s.fields) == 3 && s.fields[0] == keepIdentifier && s.fields[1] == keepLatest {
+		return func(s *state) action {
+			return parseLatest(s, &c.Latest, c.classLine)
+		}
+	}
+
+	if len(s.fields) == 3 && s.fields[0] == keepIdentifier && calendarUnits[s.fields[1]] {
+		return func(s *state) action {
+			return parseCalendar(s, &c.Calendar, c.classLine)
+		}
+	}
+
+	return s.unparsableToken()
+}
+
+func (c *Class) end(s *state) action {
+	if c.Latest == 0 && len(c.Periods) == 0 && len(c.Calendar) == 0 {
+		return s.error(ErrClassNoKeeps)
+	}
+
+	p := c.plan
+	c.plan = nil
+	p.Classes = append(p.Classes, *c)
+
+	return p.planLine
+}
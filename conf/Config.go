@@ -3,13 +3,48 @@ package conf
 import (
 	"bufio"
 	"io"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config is the top-level configuration for zfs-cleaner.
 type Config struct {
 	Plans []Plan
+
+	// MaxDestroy and MaxDestroyPercent limits how many snapshots can be
+	// destroyed per dataset in a single run. Zero means no limit. Plans
+	// can override these.
+	MaxDestroy        int
+	MaxDestroyPercent int
+
+	// Properties is the prefix of ZFS user properties used to assign
+	// plans to datasets. Empty means properties are not used.
+	Properties string
+
+	// Overlap is the policy for datasets in more than one plan. Empty
+	// means OverlapError.
+	Overlap string
+
+	// RetryAttempts is how many times a snapshot destroy failing with a
+	// transient error is attempted. Zero means no retries. RetryBackoff
+	// is the wait before the first retry, doubled for every retry up to
+	// RetryMaxWait.
+	RetryAttempts int
+	RetryBackoff  time.Duration
+	RetryMaxWait  time.Duration
 }
 
+const (
+	ErrMaxDestroy1          = Error("max-destroy must be at least 1")
+	ErrMaxDestroyPercentOOR = Error("max-destroy-percent must be between 1 and 100")
+	ErrPropertyPrefix       = Error("property prefix must contain a colon")
+	ErrUnknownOverlap       = Error("overlap must be error or union")
+	ErrRetryAttempts1       = Error("retry-attempts must be at least 1")
+	ErrRetryBackoff         = Error("retry-backoff must be positive")
+	ErrRetryMaxWait         = Error("retry-max-wait must be positive")
+)
+
 // Read will read a configuration from r.
 func (c *Config) Read(r io.Reader) error {
 	s := &state{}
@@ -37,5 +72,156 @@ func (c *Config) rootLine(s *state) action {
 		return plan.planLine
 	}
 
+	if len(s.fields) == 2 && s.fields[0] == maxDestroyIdentifier {
+		return parseLimit(s, &c.MaxDestroy, 1, 0, ErrMaxDestroy1, c.rootLine)
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == maxDestroyPercentIdentifier {
+		return parseLimit(s, &c.MaxDestroyPercent, 1, 100, ErrMaxDestroyPercentOOR, c.rootLine)
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == propertiesIdentifier {
+		return c.properties
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == overlapIdentifier {
+		return c.overlap
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == retryAttemptsIdentifier {
+		return parseLimit(s, &c.RetryAttempts, 1, 0, ErrRetryAttempts1, c.rootLine)
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == retryBackoffIdentifier {
+		return parsePositiveDuration(s, &c.RetryBackoff, ErrRetryBackoff, c.rootLine)
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == retryMaxWaitIdentifier {
+		return parsePositiveDuration(s, &c.RetryMaxWait, ErrRetryMaxWait, c.rootLine)
+	}
+
 	return s.unparsableToken()
 }
+
+func (c *Config) properties(s *state) action {
+	if len(s.fields) != 2 {
+		return s.error(ErrSyntaxError)
+	}
+
+	// ZFS requires user properties to contain a colon.
+	if !strings.ContainsRune(s.fields[1], ':') {
+		return s.error(ErrPropertyPrefix)
+	}
+
+	c.Properties = s.fields[1]
+
+	return c.rootLine
+}
+
+func (c *Config) overlap(s *state) action {
+	if len(s.fields) != 2 {
+		return s.error(ErrSyntaxError)
+	}
+
+	switch s.fields[1] {
+	case OverlapError, OverlapUnion:
+		c.Overlap = s.fields[1]
+	default:
+		return s.error(ErrUnknownOverlap)
+	}
+
+	return c.rootLine
+}
+
+// OverlapPolicy returns the effective policy for datasets in more than one
+// plan.
+func (c *Config) OverlapPolicy() string {
+	if c.Overlap == "" {
+		return OverlapError
+	}
+
+	return c.Overlap
+}
+
+// Defaults used for retries if only retry-attempts is set.
+const (
+	DefaultRetryBackoff = 5 * time.Second
+	DefaultRetryMaxWait = time.Minute
+)
+
+// RetryPolicy returns the effective retry settings. attempts is at least 1.
+func (c *Config) RetryPolicy() (attempts int, backoff time.Duration, maxWait time.Duration) {
+	attempts, backoff, maxWait = c.RetryAttempts, c.RetryBackoff, c.RetryMaxWait
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+
+	if maxWait <= 0 {
+		maxWait = DefaultRetryMaxWait
+	}
+
+	return attempts, backoff, maxWait
+}
+
+// DestroyLimits returns the effective destroy limits for plan. Limits set in
+// the plan take precedence over global limits.
+func (c *Config) DestroyLimits(plan *Plan) (maxDestroy int, maxDestroyPercent int) {
+	maxDestroy = c.MaxDestroy
+	if plan.MaxDestroy > 0 {
+		maxDestroy = plan.MaxDestroy
+	}
+
+	maxDestroyPercent = c.MaxDestroyPercent
+	if plan.MaxDestroyPercent > 0 {
+		maxDestroyPercent = plan.MaxDestroyPercent
+	}
+
+	return maxDestroy, maxDestroyPercent
+}
+
+// parseLimit will parse the second field as an integer into target. If the
+// value is less than min or - when max is positive - bigger than max, err is
+// returned.
+func parseLimit(s *state, target *int, min int64, max int64, err error, next action) action {
+	if len(s.fields) != 2 {
+		return s.error(ErrSyntaxError)
+	}
+
+	var num int64
+	num, s.err = strconv.ParseInt(s.fields[1], 10, 64)
+
+	if s.err != nil {
+		return nil
+	}
+
+	if num < min || (max > 0 && num > max) {
+		return s.error(err)
+	}
+
+	*target = int(num)
+
+	return next
+}
+
+// parsePositiveDuration will parse the second field as a duration into
+// target. If the duration is not positive, err is returned.
+func parsePositiveDuration(s *state, target *time.Duration, err error, next action) action {
+	if len(s.fields) != 2 {
+		return s.error(ErrSyntaxError)
+	}
+
+	*target, s.err = parseDuration(s.fields[1])
+	if s.err != nil {
+		return nil
+	}
+
+	if *target <= 0 {
+		return s.error(err)
+	}
+
+	return next
+}
@@ -115,6 +115,309 @@ protect sheep
 				},
 			},
 		}},
+
+		{`
+plan buh {
+path /buh
+keep 1h for 1d
+interval 10m
+jitter 1m
+}`, "", &Config{
+			Plans: []Plan{
+				{
+					Name:   "buh",
+					Paths:  []string{"/buh"},
+					Latest: 1,
+					Periods: []Period{
+						{
+							Frequency: time.Hour,
+							Age:       24 * time.Hour,
+						},
+					},
+					Interval: 10 * time.Minute,
+					Jitter:   time.Minute,
+				},
+			},
+		}},
+		{`
+plan buh {
+path /buh
+keep daily 14
+keep monthly 12
+timezone UTC
+}`, "", &Config{
+			Plans: []Plan{
+				{
+					Name:   "buh",
+					Paths:  []string{"/buh"},
+					Latest: 1,
+					Calendar: []CalendarPeriod{
+						{Unit: "daily", Count: 14},
+						{Unit: "monthly", Count: 12},
+					},
+					Location: time.UTC,
+				},
+			},
+		}},
+		{`
+plan buh {
+path /buh
+keep latest 2
+replica file /var/lib/backup/remote.list
+replica command /usr/local/bin/remote-snapshots --host backup1
+replica dataset backup/buh
+}`, "", &Config{
+			Plans: []Plan{
+				{
+					Name:   "buh",
+					Paths:  []string{"/buh"},
+					Latest: 2,
+					Replicas: []Replica{
+						{Kind: ReplicaFile, Args: []string{"/var/lib/backup/remote.list"}},
+						{Kind: ReplicaCommand, Args: []string{"/usr/local/bin/remote-snapshots", "--host", "backup1"}},
+						{Kind: ReplicaDataset, Args: []string{"backup/buh"}},
+					},
+				},
+			},
+		}},
+		{`
+max-destroy 500
+max-destroy-percent 30
+
+plan buh {
+path /buh
+keep latest 2
+max-destroy 10
+max-destroy-percent 50
+}`, "", &Config{
+			MaxDestroy:        500,
+			MaxDestroyPercent: 30,
+			Plans: []Plan{
+				{
+					Name:              "buh",
+					Paths:             []string{"/buh"},
+					Latest:            2,
+					MaxDestroy:        10,
+					MaxDestroyPercent: 50,
+				},
+			},
+		}},
+		{`
+plan buh {
+path pool/customers/*
+path pool/shared recursive
+exclude pool/customers/tmp*
+keep latest 2
+}`, "", &Config{
+			Plans: []Plan{
+				{
+					Name:           "buh",
+					Paths:          []string{"pool/customers/*"},
+					RecursivePaths: []string{"pool/shared"},
+					Excludes:       []string{"pool/customers/tmp*"},
+					Latest:         2,
+				},
+			},
+		}},
+		{"\nplan buh {\npath pool/shared recursive\n}\n", "", &Config{
+			Plans: []Plan{
+				{
+					Name:           "buh",
+					RecursivePaths: []string{"pool/shared"},
+					Latest:         1,
+				},
+			},
+		}},
+		{"\nplan buh {\npath pool/[a\n}\n", "syntax error in pattern", &Config{}},
+		{"\nplan buh {\npath pool/*/x recursive\n}\n", "syntax error in pattern", &Config{}},
+		{"\nplan buh {\npath pool\nexclude pool/[\n}\n", "syntax error in pattern", &Config{}},
+		{`
+plan buh {
+path /buh
+keep latest 2
+protect horse
+protect glob:release-2024.*
+}`, "", &Config{
+			Plans: []Plan{
+				{
+					Name:            "buh",
+					Paths:           []string{"/buh"},
+					Latest:          2,
+					Protect:         []string{"horse"},
+					ProtectPatterns: []Pattern{{Kind: PatternGlob, Expr: "release-2024.*"}},
+				},
+			},
+		}},
+		{"\nplan buh {\npath /buh\nprotect glob:[\n}\n", "invalid glob pattern: glob:[", &Config{}},
+		{"\nplan buh {\npath /buh\nprotect regex:(\n}\n", "invalid regular expression: regex:(", &Config{}},
+		{`
+plan buh {
+path /buh
+keep latest 2
+match zfs-auto-snap_*
+match glob:backup-*
+}`, "", &Config{
+			Plans: []Plan{
+				{
+					Name:   "buh",
+					Paths:  []string{"/buh"},
+					Latest: 2,
+					Match: []Pattern{
+						{Kind: PatternGlob, Expr: "zfs-auto-snap_*"},
+						{Kind: PatternGlob, Expr: "backup-*"},
+					},
+				},
+			},
+		}},
+		{"\nplan buh {\npath /buh\nmatch [\n}\n", "invalid glob pattern: [", &Config{}},
+		{`
+plan buh {
+path /buh
+keep 1d for 30d
+class hourly match zfs-auto-snap_hourly-* {
+	keep latest 24
+	keep 1h for 2d
+}
+class daily match zfs-auto-snap_daily-* { keep latest 31 }
+class monthly match zfs-auto-snap_monthly-* { keep monthly 12 }
+}`, "", &Config{
+			Plans: []Plan{
+				{
+					Name:   "buh",
+					Paths:  []string{"/buh"},
+					Latest: 1,
+					Periods: []Period{
+						{Frequency: 24 * time.Hour, Age: 30 * 24 * time.Hour},
+					},
+					Classes: []Class{
+						{
+							Name:    "hourly",
+							Match:   Pattern{Kind: PatternGlob, Expr: "zfs-auto-snap_hourly-*"},
+							Latest:  24,
+							Periods: []Period{{Frequency: time.Hour, Age: 48 * time.Hour}},
+						},
+						{
+							Name:   "daily",
+							Match:  Pattern{Kind: PatternGlob, Expr: "zfs-auto-snap_daily-*"},
+							Latest: 31,
+						},
+						{
+							Name:     "monthly",
+							Match:    Pattern{Kind: PatternGlob, Expr: "zfs-auto-snap_monthly-*"},
+							Calendar: []CalendarPeriod{{Unit: "monthly", Count: 12}},
+						},
+					},
+				},
+			},
+		}},
+		{"\nplan buh {\npath /buh\nclass a match a-* {\n}\n}\n", "no keep rules defined in class", &Config{}},
+		{"\nplan buh {\npath /buh\nclass a match a-* {\nprotect x\n}\n}\n", "unparseable tokens: [protect x]", &Config{}},
+		{"\nplan buh {\npath /buh\nclass a like a-* {\n}\n", "syntax error", &Config{}},
+		{"\nplan buh {\npath /buh\nclass a match a-* { keep latest 1\n}\n", "syntax error", &Config{}},
+		{"\nplan buh {\npath /buh\nclass a match a-* {\nkeep latest 1\n", "unterminated plan", &Config{}},
+		{"\nplan buh {\npath /buh\nanchor newest\n}\n", "", &Config{
+			Plans: []Plan{
+				{
+					Name:   "buh",
+					Paths:  []string{"/buh"},
+					Latest: 1,
+					Anchor: AnchorNewest,
+				},
+			},
+		}},
+		{"\nplan buh {\npath /buh\nanchor yesterday\n}\n", "anchor must be now or newest", &Config{}},
+		{"\nplan buh {\npath /buh\nclones error\n}\n", "", &Config{
+			Plans: []Plan{
+				{
+					Name:   "buh",
+					Paths:  []string{"/buh"},
+					Latest: 1,
+					Clones: ClonesError,
+				},
+			},
+		}},
+		{"\nplan buh {\npath /buh\nclones destroy pool/ci/* glob:pool/tmp-*\n}\n", "", &Config{
+			Plans: []Plan{
+				{
+					Name:           "buh",
+					Paths:          []string{"/buh"},
+					Latest:         1,
+					Clones:         ClonesDestroy,
+					CloneAllowlist: []Pattern{{Kind: PatternGlob, Expr: "pool/ci/*"}, {Kind: PatternGlob, Expr: "pool/tmp-*"}},
+				},
+			},
+		}},
+		{"\nplan buh {\npath /buh\nclones destroy\n}\n", "clones destroy needs patterns of clones allowed to be destroyed", &Config{}},
+		{"\nplan buh {\npath /buh\nclones keep pool/ci/*\n}\n", "syntax error", &Config{}},
+		{"\nplan buh {\npath /buh\nclones ignore\n}\n", "clones must be keep, error or destroy", &Config{}},
+		{"\nplan buh {\npath /buh\nclones destroy glob:[\n}\n", "invalid glob pattern: glob:[", &Config{}},
+		{"\nplan buh {\npath /buh\npause-if-stale 6h\n}\n", "", &Config{
+			Plans: []Plan{
+				{
+					Name:         "buh",
+					Paths:        []string{"/buh"},
+					Latest:       1,
+					PauseIfStale: 6 * time.Hour,
+				},
+			},
+		}},
+		{"\nplan buh {\npath /buh\npause-if-stale 0s\n}\n", "pause-if-stale must be positive", &Config{}},
+		{"\nplan buh {\npath /buh\nexpect-every 1h\n}\n", "", &Config{
+			Plans: []Plan{
+				{
+					Name:        "buh",
+					Paths:       []string{"/buh"},
+					Latest:      1,
+					ExpectEvery: time.Hour,
+				},
+			},
+		}},
+		{"\nplan buh {\npath /buh\nexpect-every 0s\n}\n", "expect-every must be positive", &Config{}},
+		{"\nplan buh {\npath /buh\ntarget-free 20%\nmax-snapshot-usage 2T\n}\n", "", &Config{
+			Plans: []Plan{
+				{
+					Name:              "buh",
+					Paths:             []string{"/buh"},
+					Latest:            1,
+					TargetFreePercent: 20,
+					MaxSnapshotUsage:  2 << 40,
+				},
+			},
+		}},
+		{"\nplan buh {\npath /buh\ntarget-free 100%\n}\n", "percentage must be between 1% and 99%", &Config{}},
+		{"\nplan buh {\npath /buh\nmax-snapshot-usage 0G\n}\n", "max-snapshot-usage must be positive", &Config{}},
+		{"properties cego:zfs-cleaner\n", "", &Config{Properties: "cego:zfs-cleaner"}},
+		{"properties zfs-cleaner\n", "property prefix must contain a colon", &Config{}},
+		// The example from README.md.
+		{"properties cego:zfs-cleaner\n\nplan longlived {\n    keep 1d for 1y\n}\n", "", &Config{
+			Properties: "cego:zfs-cleaner",
+			Plans: []Plan{
+				{
+					Name:    "longlived",
+					Latest:  1,
+					Periods: []Period{{Frequency: 24 * time.Hour, Age: 365 * 24 * time.Hour}},
+				},
+			},
+		}},
+		{"plan longlived {\nkeep 1d for 1y\n}\nproperties cego:zfs-cleaner\n", "no paths defined", &Config{}},
+		{"overlap union\n", "", &Config{Overlap: OverlapUnion}},
+		{"overlap error\n", "", &Config{Overlap: OverlapError}},
+		{"overlap sometimes\n", "overlap must be error or union", &Config{}},
+		{"retry-attempts 3\nretry-backoff 10s\nretry-max-wait 2m\n", "", &Config{RetryAttempts: 3, RetryBackoff: 10 * time.Second, RetryMaxWait: 2 * time.Minute}},
+		{"retry-attempts 0\n", "retry-attempts must be at least 1", &Config{}},
+		{"retry-backoff 0s\n", "retry-backoff must be positive", &Config{}},
+		{"retry-max-wait 0s\n", "retry-max-wait must be positive", &Config{}},
+		{"retry-backoff 1x\n", "unknown unit", &Config{}},
+		{"max-destroy 0\n", "max-destroy must be at least 1", &Config{}},
+		{"max-destroy-percent 101\n", "max-destroy-percent must be between 1 and 100", &Config{}},
+		{"\nplan buh {\npath /buh\nmax-destroy-percent 0\n}\n", "max-destroy-percent must be between 1 and 100", &Config{}},
+		{"\nplan buh {\npath /buh\nreplica file a b\n}\n", "syntax error", &Config{}},
+		{"\nplan buh {\npath /buh\nreplica carrier pigeon\n}\n", "unparseable tokens: [replica carrier pigeon]", &Config{}},
+		{"\nplan buh {\npath /buh\nkeep daily 0\n}\n", "calendar keep count must be at least 1", &Config{}},
+		{"\nplan buh {\npath /buh\nkeep daily 1\ntimezone Nowhere/Special\n}\n", "unknown time zone Nowhere/Special", &Config{}},
+		{"\nplan buh {\npath /buh\nkeep 1h for 1d\ninterval 1h\n}\n", "interval must be shorter than the smallest keep frequency", &Config{}},
+		{"\nplan buh {\npath /buh\nkeep 1h for 1d\njitter 30m\n}\n", "jitter must be shorter than interval", &Config{}},
+		{"\nplan buh {\npath /buh\nkeep latest 1\ninterval 1x\n}\n", "unknown unit", &Config{}},
 	}
 
 	for i, c := range cases {
@@ -210,3 +513,61 @@ func TestPlanLine(t *testing.T) {
 		}
 	}
 }
+
+func TestDestroyLimits(t *testing.T) {
+	c := &Config{MaxDestroy: 500, MaxDestroyPercent: 30}
+
+	cases := []struct {
+		plan    Plan
+		max     int
+		percent int
+	}{
+		{Plan{}, 500, 30},
+		{Plan{MaxDestroy: 10}, 10, 30},
+		{Plan{MaxDestroyPercent: 90}, 500, 90},
+	}
+
+	for i, cc := range cases {
+		max, percent := c.DestroyLimits(&cc.plan)
+		if max != cc.max || percent != cc.percent {
+			t.Fatalf("%d DestroyLimits() returned %d, %d - expected %d, %d", i, max, percent, cc.max, cc.percent)
+		}
+	}
+}
+
+func TestOverlapPolicy(t *testing.T) {
+	cases := []struct {
+		config   Config
+		expected string
+	}{
+		{Config{}, OverlapError},
+		{Config{Overlap: OverlapError}, OverlapError},
+		{Config{Overlap: OverlapUnion}, OverlapUnion},
+	}
+
+	for i, c := range cases {
+		if policy := c.config.OverlapPolicy(); policy != c.expected {
+			t.Errorf("%d OverlapPolicy() returned %s, expected %s", i, policy, c.expected)
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	cases := []struct {
+		config   Config
+		attempts int
+		backoff  time.Duration
+		maxWait  time.Duration
+	}{
+		{Config{}, 1, DefaultRetryBackoff, DefaultRetryMaxWait},
+		{Config{RetryAttempts: 3}, 3, DefaultRetryBackoff, DefaultRetryMaxWait},
+		{Config{RetryAttempts: 3, RetryBackoff: time.Second, RetryMaxWait: time.Hour}, 3, time.Second, time.Hour},
+	}
+
+	for i, c := range cases {
+		attempts, backoff, maxWait := c.config.RetryPolicy()
+		if attempts != c.attempts || backoff != c.backoff || maxWait != c.maxWait {
+			t.Errorf("%d RetryPolicy() returned %d, %s, %s - expected %d, %s, %s", i, attempts, backoff, maxWait, c.attempts, c.backoff, c.maxWait)
+		}
+	}
+}
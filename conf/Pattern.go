@@ -0,0 +1,92 @@
+package conf
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// PatternGlob matches snapshot names using shell-style globbing.
+	PatternGlob = "glob"
+
+	// PatternRegex matches snapshot names using a regular expression.
+	// The expression must match the entire name.
+	PatternRegex = "regex"
+)
+
+const (
+	ErrBadGlob  = Error("invalid glob pattern")
+	ErrBadRegex = Error("invalid regular expression")
+)
+
+// Pattern matches snapshot names for protect rules.
+type Pattern struct {
+	Kind string
+	Expr string
+
+	re *regexp.Regexp
+}
+
+// parsePattern parses a protect value on the form "glob:expr" or
+// "regex:expr". The second return value is false if value is not a pattern.
+func parsePattern(value string) (Pattern, bool, error) {
+	i := strings.IndexByte(value, ':')
+	if i < 0 {
+		return Pattern{}, false, nil
+	}
+
+	p := Pattern{
+		Kind: value[:i],
+		Expr: value[i+1:],
+	}
+
+	switch p.Kind {
+	case PatternGlob:
+		_, err := path.Match(p.Expr, "")
+		if err != nil {
+			return Pattern{}, true, ErrBadGlob
+		}
+
+	case PatternRegex:
+		re, err := regexp.Compile("^(?:" + p.Expr + ")$")
+		if err != nil {
+			return Pattern{}, true, ErrBadRegex
+		}
+		p.re = re
+
+	default:
+		return Pattern{}, false, nil
+	}
+
+	return p, true, nil
+}
+
+// parseMatch parses the value of a match directive. Values without a kind
+// prefix are glob patterns.
+func parseMatch(value string) (Pattern, error) {
+	p, isPattern, err := parsePattern(value)
+	if err != nil || isPattern {
+		return p, err
+	}
+
+	p, _, err = parsePattern(PatternGlob + ":" + value)
+
+	return p, err
+}
+
+// Match returns true if the snapshot name matches the pattern.
+func (p Pattern) Match(name string) bool {
+	if p.Kind == PatternGlob {
+		matched, _ := path.Match(p.Expr, name)
+
+		return matched
+	}
+
+	return p.re != nil && p.re.MatchString(name)
+}
+
+// String returns the pattern as written in a configuration file.
+func (p Pattern) String() string {
+	return p.Kind + ":" + p.Expr
+}
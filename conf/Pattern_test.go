@@ -0,0 +1,58 @@
+package conf
+
+import (
+	"testing"
+)
+
+func TestParsePattern(t *testing.T) {
+	cases := []struct {
+		in        string
+		isPattern bool
+		err       error
+	}{
+		{"plain", false, nil},
+		{"unknown:kind", false, nil},
+		{"glob:release-2024.*", true, nil},
+		{"glob:[", true, ErrBadGlob},
+		{"regex:pre-upgrade-[0-9]+", true, nil},
+		{"regex:(", true, ErrBadRegex},
+	}
+
+	for i, c := range cases {
+		_, isPattern, err := parsePattern(c.in)
+		if isPattern != c.isPattern || err != c.err {
+			t.Fatalf("%d parsePattern(%s) returned %v, %v - expected %v, %v", i, c.in, isPattern, err, c.isPattern, c.err)
+		}
+	}
+}
+
+func TestPatternMatch(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"glob:release-2024.*", "release-2024.03", true},
+		{"glob:release-2024.*", "release-2023.03", false},
+		{"glob:pre-upgrade-*", "pre-upgrade-5.2", true},
+		{"regex:pre-upgrade-[0-9]+", "pre-upgrade-52", true},
+		{"regex:pre-upgrade-[0-9]+", "pre-upgrade-52-old", false},
+		{"regex:upgrade", "pre-upgrade-52", false},
+		{"regex:.*upgrade.*", "pre-upgrade-52", true},
+	}
+
+	for i, c := range cases {
+		p, _, err := parsePattern(c.pattern)
+		if err != nil {
+			t.Fatalf("%d parsePattern(%s) returned error: %s", i, c.pattern, err.Error())
+		}
+
+		if p.Match(c.name) != c.expected {
+			t.Fatalf("%d %s Match(%s) did not return %v", i, c.pattern, c.name, c.expected)
+		}
+
+		if p.String() != c.pattern {
+			t.Fatalf("%d String() returned %s, expected %s", i, p.String(), c.pattern)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package conf
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -9,3 +10,20 @@ type Period struct {
 	Frequency time.Duration
 	Age       time.Duration
 }
+
+// String returns the period as written in a configuration file.
+func (p Period) String() string {
+	return fmt.Sprintf("%s %s %s %s", keepIdentifier, formatDuration(p.Frequency), keepFor, formatDuration(p.Age))
+}
+
+// CalendarPeriod keeps the first snapshot in each of the Count latest calendar
+// buckets of Unit. Unit is one of hourly, daily, weekly, monthly or yearly.
+type CalendarPeriod struct {
+	Unit  string
+	Count int
+}
+
+// String returns the calendar period as written in a configuration file.
+func (c CalendarPeriod) String() string {
+	return fmt.Sprintf("%s %s %d", keepIdentifier, c.Unit, c.Count)
+}
@@ -0,0 +1,23 @@
+package conf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeriodString(t *testing.T) {
+	cases := []struct {
+		in  Period
+		out string
+	}{
+		{Period{Frequency: time.Hour, Age: 48 * time.Hour}, "keep 1h for 2d"},
+		{Period{Frequency: 0, Age: 12 * time.Hour}, "keep 0s for 12h"},
+		{Period{Frequency: 30 * 24 * time.Hour, Age: 2 * 365 * 24 * time.Hour}, "keep 30d for 2y"},
+	}
+
+	for i, c := range cases {
+		if c.in.String() != c.out {
+			t.Fatalf("%d String() returned '%s', expected '%s'", i, c.in.String(), c.out)
+		}
+	}
+}
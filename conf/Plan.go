@@ -2,13 +2,17 @@ package conf
 
 import (
 	"bufio"
+	"fmt"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
 )
 
 // Plan is a description of how the cleaner should behave for specific paths.
+// Paths can be literal dataset names or glob patterns. Use Datasets to
+// resolve them.
 type Plan struct {
 	Name    string
 	Paths   []string
@@ -16,6 +20,85 @@ type Plan struct {
 	Periods []Period
 	conf    *Config
 	Protect []string
+
+	// ProtectPatterns holds the protect values using the glob: or regex:
+	// form. These are not included in Protect.
+	ProtectPatterns []Pattern
+
+	// Match limits the plan to snapshots matching any of the patterns.
+	// Other snapshots are left alone. If empty, all snapshots are managed.
+	Match []Pattern
+
+	// Classes holds retention classes. Snapshots matching a class are
+	// sieved by the class only. Other snapshots are sieved using the keep
+	// rules of the plan.
+	Classes []Class
+
+	// Anchor is the point in time periods are measured from. Empty means
+	// AnchorNow.
+	Anchor string
+
+	// PauseIfStale will pause destroying snapshots in a dataset if the
+	// newest snapshot is older than this. Zero means never.
+	PauseIfStale time.Duration
+
+	// ExpectEvery is how often new snapshots are expected. This is used by
+	// check-freshness. Zero means not checked.
+	ExpectEvery time.Duration
+
+	// TargetFreePercent and MaxSnapshotUsage will destroy snapshots kept
+	// only by periods, oldest first, until the pool has this much free
+	// space in percent, and the dataset uses at most this many bytes for
+	// snapshots. Zero means no target.
+	TargetFreePercent int
+	MaxSnapshotUsage  uint64
+
+	// Clones is the policy for snapshots with dependent clones. Empty
+	// means ClonesKeep. With ClonesDestroy, snapshots are destroyed
+	// with their clones only if every clone matches CloneAllowlist.
+	Clones         string
+	CloneAllowlist []Pattern
+
+	// Interval and Jitter are used by the daemon to schedule runs. Zero
+	// means "not set".
+	Interval time.Duration
+	Jitter   time.Duration
+
+	// Calendar periods are evaluated in Location. A nil Location means
+	// local time.
+	Calendar []CalendarPeriod
+	Location *time.Location
+
+	// Replicas lists replication peers. The newest snapshot common with
+	// each peer will always be kept.
+	Replicas []Replica
+
+	// MaxDestroy and MaxDestroyPercent overrides the global limits from
+	// Config when non-zero.
+	MaxDestroy        int
+	MaxDestroyPercent int
+
+	// RecursivePaths will include the dataset and all its descendants.
+	RecursivePaths []string
+
+	// Excludes is glob patterns of datasets to leave out when resolving
+	// paths. Descendants of excluded datasets are excluded as well.
+	Excludes []string
+}
+
+// Replica describes how to find the snapshot names of a replication peer.
+type Replica struct {
+	// Kind is one of ReplicaFile, ReplicaCommand or ReplicaDataset.
+	Kind string
+
+	// Args is the path for ReplicaFile, the command and its arguments
+	// for ReplicaCommand and the dataset name for ReplicaDataset.
+	Args []string
+}
+
+// String returns the replica as written in a configuration file.
+func (r Replica) String() string {
+	return strings.Join(append([]string{replicaIdentifier, r.Kind}, r.Args...), " ")
 }
 
 const (
@@ -26,6 +109,16 @@ const (
 	ErrNoPaths          = Error("no paths defined")
 	ErrNoKeeps          = Error("no keep periods defined")
 	ErrProtectPath      = Error("protected snapshot name include path")
+	ErrIntervalTooBig   = Error("interval must be shorter than the smallest keep frequency")
+	ErrJitterTooBig     = Error("jitter must be shorter than interval")
+	ErrCalendar1        = Error("calendar keep count must be at least 1")
+	ErrBadPattern       = Error("syntax error in pattern")
+	ErrUnknownAnchor    = Error("anchor must be now or newest")
+	ErrPauseIfStale     = Error("pause-if-stale must be positive")
+	ErrExpectEvery      = Error("expect-every must be positive")
+	ErrMaxSnapshotUsage = Error("max-snapshot-usage must be positive")
+	ErrUnknownClones    = Error("clones must be keep, error or destroy")
+	ErrCloneAllowlist   = Error("clones destroy needs patterns of clones allowed to be destroyed")
 )
 
 func (p *Plan) planLine(s *state) action {
@@ -41,14 +134,82 @@ func (p *Plan) planLine(s *state) action {
 		return p.keepLatest
 	}
 
+	if len(s.fields) == 3 && s.fields[0] == keepIdentifier && calendarUnits[s.fields[1]] {
+		return p.keepCalendar
+	}
+
+	if len(s.fields) >= 3 && s.fields[0] == replicaIdentifier {
+		return p.replica
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == maxDestroyIdentifier {
+		return parseLimit(s, &p.MaxDestroy, 1, 0, ErrMaxDestroy1, p.planLine)
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == maxDestroyPercentIdentifier {
+		return parseLimit(s, &p.MaxDestroyPercent, 1, 100, ErrMaxDestroyPercentOOR, p.planLine)
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == timezoneIdentifier {
+		return p.timezone
+	}
+
 	if len(s.fields) == 2 && s.fields[0] == pathIdentifier {
 		return p.path
 	}
 
+	if len(s.fields) == 3 && s.fields[0] == pathIdentifier && s.fields[2] == pathRecursive {
+		return p.pathRecursive
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == excludeIdentifier {
+		return p.exclude
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == matchIdentifier {
+		return p.match
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == targetFreeIdentifier {
+		return p.targetFree
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == maxSnapshotUsageIdentifier {
+		return p.maxSnapshotUsage
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == expectEveryIdentifier {
+		return p.expectEvery
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == pauseIfStaleIdentifier {
+		return p.pauseIfStale
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == anchorIdentifier {
+		return p.anchor
+	}
+
+	if len(s.fields) >= 5 && s.fields[0] == classIdentifier {
+		return p.class
+	}
+
+	if len(s.fields) >= 2 && s.fields[0] == clonesIdentifier {
+		return p.clones
+	}
+
 	if len(s.fields) == 2 && s.fields[0] == protectIdentifier {
 		return p.protect
 	}
 
+	if len(s.fields) == 2 && s.fields[0] == intervalIdentifier {
+		return p.interval
+	}
+
+	if len(s.fields) == 2 && s.fields[0] == jitterIdentifier {
+		return p.jitter
+	}
+
 	if len(s.fields) == 1 && s.fields[0] == blockEnd {
 		return p.end
 	}
@@ -57,6 +218,19 @@ func (p *Plan) planLine(s *state) action {
 }
 
 func (p *Plan) keep(s *state) action {
+	return parsePeriod(s, &p.Periods, p.planLine)
+}
+
+func (p *Plan) keepLatest(s *state) action {
+	return parseLatest(s, &p.Latest, p.planLine)
+}
+
+func (p *Plan) keepCalendar(s *state) action {
+	return parseCalendar(s, &p.Calendar, p.planLine)
+}
+
+// parsePeriod parses "keep X for Y" and appends the period to target.
+func parsePeriod(s *state, target *[]Period, next action) action {
 	if len(s.fields) < 4 {
 		return s.error(ErrSyntaxError)
 	}
@@ -84,12 +258,13 @@ func (p *Plan) keep(s *state) action {
 		Age:       age,
 	}
 
-	p.Periods = append(p.Periods, r)
+	*target = append(*target, r)
 
-	return p.planLine
+	return next
 }
 
-func (p *Plan) keepLatest(s *state) action {
+// parseLatest parses "keep latest N" and stores N in target.
+func parseLatest(s *state, target *int, next action) action {
 	if len(s.fields) != 3 {
 		return s.error(ErrSyntaxError)
 	}
@@ -105,7 +280,71 @@ func (p *Plan) keepLatest(s *state) action {
 		return s.error(ErrLatest1)
 	}
 
-	p.Latest = int(num)
+	*target = int(num)
+
+	return next
+}
+
+// parseCalendar parses calendar keeps like "keep daily 14" and appends the
+// period to target.
+func parseCalendar(s *state, target *[]CalendarPeriod, next action) action {
+	if len(s.fields) != 3 || !calendarUnits[s.fields[1]] {
+		return s.error(ErrSyntaxError)
+	}
+
+	var num int64
+	num, s.err = strconv.ParseInt(s.fields[2], 10, 64)
+
+	if s.err != nil {
+		return nil
+	}
+
+	if num < 1 {
+		return s.error(ErrCalendar1)
+	}
+
+	*target = append(*target, CalendarPeriod{
+		Unit:  s.fields[1],
+		Count: int(num),
+	})
+
+	return next
+}
+
+func (p *Plan) timezone(s *state) action {
+	if len(s.fields) != 2 {
+		return s.error(ErrSyntaxError)
+	}
+
+	p.Location, s.err = time.LoadLocation(s.fields[1])
+	if s.err != nil {
+		return nil
+	}
+
+	return p.planLine
+}
+
+func (p *Plan) replica(s *state) action {
+	if len(s.fields) < 3 {
+		return s.error(ErrSyntaxError)
+	}
+
+	kind := s.fields[1]
+
+	switch kind {
+	case ReplicaFile, ReplicaDataset:
+		if len(s.fields) != 3 {
+			return s.error(ErrSyntaxError)
+		}
+	case ReplicaCommand:
+	default:
+		return s.unparsableToken()
+	}
+
+	p.Replicas = append(p.Replicas, Replica{
+		Kind: kind,
+		Args: append([]string(nil), s.fields[2:]...),
+	})
 
 	return p.planLine
 }
@@ -115,11 +354,223 @@ func (p *Plan) path(s *state) action {
 		return s.error(ErrSyntaxError)
 	}
 
+	_, err := path.Match(s.fields[1], "")
+	if err != nil {
+		return s.error(ErrBadPattern)
+	}
+
 	p.Paths = append(p.Paths, s.fields[1])
 
 	return p.planLine
 }
 
+func (p *Plan) pathRecursive(s *state) action {
+	if len(s.fields) != 3 || s.fields[2] != pathRecursive {
+		return s.error(ErrSyntaxError)
+	}
+
+	if isPattern(s.fields[1]) {
+		return s.error(ErrBadPattern)
+	}
+
+	p.RecursivePaths = append(p.RecursivePaths, s.fields[1])
+
+	return p.planLine
+}
+
+func (p *Plan) exclude(s *state) action {
+	if len(s.fields) != 2 {
+		return s.error(ErrSyntaxError)
+	}
+
+	_, err := path.Match(s.fields[1], "")
+	if err != nil {
+		return s.error(ErrBadPattern)
+	}
+
+	p.Excludes = append(p.Excludes, s.fields[1])
+
+	return p.planLine
+}
+
+func (p *Plan) match(s *state) action {
+	if len(s.fields) != 2 {
+		return s.error(ErrSyntaxError)
+	}
+
+	pattern, err := parseMatch(s.fields[1])
+	if err != nil {
+		return s.error(fmt.Errorf("%s: %s", err.Error(), s.fields[1]))
+	}
+
+	p.Match = append(p.Match, pattern)
+
+	return p.planLine
+}
+
+func (p *Plan) clones(s *state) action {
+	if len(s.fields) < 2 {
+		return s.error(ErrSyntaxError)
+	}
+
+	switch s.fields[1] {
+	case ClonesKeep, ClonesError:
+		if len(s.fields) != 2 {
+			return s.error(ErrSyntaxError)
+		}
+
+	case ClonesDestroy:
+		if len(s.fields) < 3 {
+			return s.error(ErrCloneAllowlist)
+		}
+
+		for _, value := range s.fields[2:] {
+			pattern, err := parseMatch(value)
+			if err != nil {
+				return s.error(fmt.Errorf("%s: %s", err.Error(), value))
+			}
+
+			p.CloneAllowlist = append(p.CloneAllowlist, pattern)
+		}
+
+	default:
+		return s.error(ErrUnknownClones)
+	}
+
+	p.Clones = s.fields[1]
+
+	return p.planLine
+}
+
+// ClonePolicy returns the effective policy for snapshots with clones.
+func (p *Plan) ClonePolicy() string {
+	if p.Clones == "" {
+		return ClonesKeep
+	}
+
+	return p.Clones
+}
+
+// CloneAllowed returns true if the clone named clone can be destroyed.
+func (p *Plan) CloneAllowed(clone string) bool {
+	if p.ClonePolicy() != ClonesDestroy {
+		return false
+	}
+
+	for _, pattern := range p.CloneAllowlist {
+		if pattern.Match(clone) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Manages returns true if the snapshot name is managed by the plan.
+func (p *Plan) Manages(name string) bool {
+	if len(p.Match) == 0 {
+		return true
+	}
+
+	for _, pattern := range p.Match {
+		if pattern.Match(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isPattern returns true if value contains glob meta characters.
+func isPattern(value string) bool {
+	return strings.ContainsAny(value, `*?[\`)
+}
+
+// HasPatterns returns true if the plan paths must be resolved against the
+// list of filesystems.
+func (p *Plan) HasPatterns() bool {
+	if len(p.RecursivePaths) > 0 || len(p.Excludes) > 0 {
+		return true
+	}
+
+	for _, path := range p.Paths {
+		if isPattern(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// excluded returns true if dataset or one of its ancestors matches an
+// exclude pattern.
+func (p *Plan) excluded(dataset string) bool {
+	for _, exclude := range p.Excludes {
+		for name := dataset; name != "."; name = path.Dir(name) {
+			if matched, _ := path.Match(exclude, name); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Datasets resolves the paths of the plan against filesystems. Literal paths
+// are returned even if they are not present in filesystems. Each dataset is
+// returned once, in the order first matched.
+func (p *Plan) Datasets(filesystems []string) []string {
+	seen := make(map[string]bool)
+	datasets := []string{}
+
+	add := func(dataset string) {
+		if !seen[dataset] && !p.excluded(dataset) {
+			seen[dataset] = true
+			datasets = append(datasets, dataset)
+		}
+	}
+
+	for _, pattern := range p.Paths {
+		if !isPattern(pattern) {
+			add(pattern)
+
+			continue
+		}
+
+		for _, filesystem := range filesystems {
+			if matched, _ := path.Match(pattern, filesystem); matched {
+				add(filesystem)
+			}
+		}
+	}
+
+	for _, root := range p.RecursivePaths {
+		add(root)
+
+		for _, filesystem := range filesystems {
+			if strings.HasPrefix(filesystem, root+"/") {
+				add(filesystem)
+			}
+		}
+	}
+
+	return datasets
+}
+
+// NamedDatasets returns the datasets named by the plan without patterns.
+// Unlike datasets matched by patterns, these are expected to exist.
+func (p *Plan) NamedDatasets() []string {
+	datasets := []string{}
+
+	for _, dataset := range append(append([]string{}, p.Paths...), p.RecursivePaths...) {
+		if !isPattern(dataset) && !p.excluded(dataset) {
+			datasets = append(datasets, dataset)
+		}
+	}
+
+	return datasets
+}
+
 func readValue(s *state, value string, target *[]string, next action) action {
 	if value[0] == '<' {
 		return readFile(s, strings.TrimSpace(value[1:]), target, next)
@@ -155,8 +606,156 @@ func (p *Plan) protect(s *state) action {
 	return readValue(s, s.fields[1], &p.Protect, p.planLine)
 }
 
+func (p *Plan) anchor(s *state) action {
+	if len(s.fields) != 2 {
+		return s.error(ErrSyntaxError)
+	}
+
+	switch s.fields[1] {
+	case AnchorNow, AnchorNewest:
+		p.Anchor = s.fields[1]
+	default:
+		return s.error(ErrUnknownAnchor)
+	}
+
+	return p.planLine
+}
+
+func (p *Plan) pauseIfStale(s *state) action {
+	if len(s.fields) != 2 {
+		return s.error(ErrSyntaxError)
+	}
+
+	p.PauseIfStale, s.err = parseDuration(s.fields[1])
+	if s.err != nil {
+		return nil
+	}
+
+	if p.PauseIfStale <= 0 {
+		return s.error(ErrPauseIfStale)
+	}
+
+	return p.planLine
+}
+
+func (p *Plan) expectEvery(s *state) action {
+	if len(s.fields) != 2 {
+		return s.error(ErrSyntaxError)
+	}
+
+	p.ExpectEvery, s.err = parseDuration(s.fields[1])
+	if s.err != nil {
+		return nil
+	}
+
+	if p.ExpectEvery <= 0 {
+		return s.error(ErrExpectEvery)
+	}
+
+	return p.planLine
+}
+
+func (p *Plan) targetFree(s *state) action {
+	if len(s.fields) != 2 {
+		return s.error(ErrSyntaxError)
+	}
+
+	p.TargetFreePercent, s.err = parsePercent(s.fields[1])
+	if s.err != nil {
+		return nil
+	}
+
+	return p.planLine
+}
+
+func (p *Plan) maxSnapshotUsage(s *state) action {
+	if len(s.fields) != 2 {
+		return s.error(ErrSyntaxError)
+	}
+
+	p.MaxSnapshotUsage, s.err = parseSize(s.fields[1])
+	if s.err != nil {
+		return nil
+	}
+
+	if p.MaxSnapshotUsage == 0 {
+		return s.error(ErrMaxSnapshotUsage)
+	}
+
+	return p.planLine
+}
+
+func (p *Plan) interval(s *state) action {
+	if len(s.fields) != 2 {
+		return s.error(ErrSyntaxError)
+	}
+
+	p.Interval, s.err = parseDuration(s.fields[1])
+	if s.err != nil {
+		return nil
+	}
+
+	return p.planLine
+}
+
+func (p *Plan) jitter(s *state) action {
+	if len(s.fields) != 2 {
+		return s.error(ErrSyntaxError)
+	}
+
+	p.Jitter, s.err = parseDuration(s.fields[1])
+	if s.err != nil {
+		return nil
+	}
+
+	return p.planLine
+}
+
+// allPeriods returns the periods of the plan and of all its classes.
+func (p *Plan) allPeriods() []Period {
+	periods := append([]Period{}, p.Periods...)
+	for _, class := range p.Classes {
+		periods = append(periods, class.Periods...)
+	}
+
+	return periods
+}
+
+// RunInterval returns how often the plan should be executed. If no interval
+// is configured, the interval is derived from the smallest keep frequency.
+// If the plan has no periods to derive from, fallback is returned.
+func (p *Plan) RunInterval(fallback time.Duration) time.Duration {
+	if p.Interval > 0 {
+		return p.Interval
+	}
+
+	var smallest time.Duration
+
+	for _, period := range p.allPeriods() {
+		// Frequencies below a second means "keep everything". They
+		// do not depend on when we run.
+		if period.Frequency < time.Second {
+			continue
+		}
+
+		if smallest == 0 || period.Frequency < smallest {
+			smallest = period.Frequency
+		}
+	}
+
+	// We must run more often than the smallest frequency to be
+	// consistent across hosts. See SnapshotList.Sieve().
+	if smallest > 0 {
+		return smallest / 2
+	}
+
+	return fallback
+}
+
 func (p *Plan) end(s *state) action {
-	if len(p.Paths) == 0 {
+	// With properties, datasets can be assigned to the plan without
+	// listing any paths.
+	if len(p.Paths) == 0 && len(p.RecursivePaths) == 0 && p.conf.Properties == "" {
 		return s.error(ErrNoPaths)
 	}
 
@@ -164,10 +763,36 @@ func (p *Plan) end(s *state) action {
 		return s.error(ErrNoKeeps)
 	}
 
+	var names []string
 	for _, protect := range p.Protect {
+		pattern, isPattern, err := parsePattern(protect)
+		if err != nil {
+			return s.error(fmt.Errorf("%s: %s", err.Error(), protect))
+		}
+
 		if strings.ContainsRune(protect, '@') {
 			return s.error(ErrProtectPath)
 		}
+
+		if isPattern {
+			p.ProtectPatterns = append(p.ProtectPatterns, pattern)
+		} else {
+			names = append(names, protect)
+		}
+	}
+	p.Protect = names
+
+	if p.Interval > 0 {
+		for _, period := range p.allPeriods() {
+			if period.Frequency >= time.Second && p.Interval >= period.Frequency {
+				return s.error(ErrIntervalTooBig)
+			}
+		}
+	}
+
+	interval := p.RunInterval(0)
+	if interval > 0 && p.Jitter >= interval {
+		return s.error(ErrJitterTooBig)
 	}
 
 	c := p.conf
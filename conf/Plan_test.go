@@ -3,9 +3,11 @@ package conf
 import (
 	"bufio"
 	"io/ioutil"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestPlanLineError(t *testing.T) {
@@ -282,3 +284,206 @@ func TestProtectFileError(t *testing.T) {
 		}
 	}
 }
+
+func TestRunInterval(t *testing.T) {
+	cases := []struct {
+		plan     Plan
+		fallback time.Duration
+		expected time.Duration
+	}{
+		{Plan{}, time.Hour, time.Hour},
+		{Plan{Interval: time.Minute}, time.Hour, time.Minute},
+		{Plan{Periods: []Period{{Frequency: 0, Age: time.Hour}}}, time.Hour, time.Hour},
+		{Plan{Periods: []Period{{Frequency: 2 * time.Hour, Age: 48 * time.Hour}, {Frequency: 24 * time.Hour, Age: 720 * time.Hour}}}, time.Hour, time.Hour},
+		{Plan{Periods: []Period{{Frequency: 24 * time.Hour, Age: 720 * time.Hour}}}, time.Hour, 12 * time.Hour},
+		{Plan{Interval: time.Minute, Periods: []Period{{Frequency: 24 * time.Hour, Age: 720 * time.Hour}}}, time.Hour, time.Minute},
+		{Plan{Periods: []Period{{Frequency: 24 * time.Hour, Age: 720 * time.Hour}}, Classes: []Class{{Periods: []Period{{Frequency: time.Hour, Age: 48 * time.Hour}}}}}, time.Hour, 30 * time.Minute},
+	}
+
+	for i, c := range cases {
+		interval := c.plan.RunInterval(c.fallback)
+		if interval != c.expected {
+			t.Fatalf("%d RunInterval() returned %s, expected %s", i, interval, c.expected)
+		}
+	}
+}
+
+func TestKeepCalendar(t *testing.T) {
+	c := &Config{}
+	s := &state{}
+	p := &Plan{
+		Name: "testplan",
+		conf: c,
+	}
+
+	cases := []string{"keep hourly 24", "keep daily 14", "keep weekly 8", "keep monthly 12", "keep yearly 5 // comment"}
+	for i, cc := range cases {
+		s.scanner = bufio.NewScanner(strings.NewReader(cc))
+		s.scanLine()
+
+		ret := p.keepCalendar(s)
+
+		if s.err != nil {
+			t.Fatalf("%d keepCalendar() returned unexpected error: %s", i, s.err.Error())
+		}
+
+		if ret == nil {
+			t.Fatalf("%d keepCalendar() did not return action", i)
+		}
+	}
+
+	if len(p.Calendar) != len(cases) {
+		t.Fatalf("keepCalendar() added %d calendar periods, expected %d", len(p.Calendar), len(cases))
+	}
+
+	if p.Calendar[1].String() != "keep daily 14" {
+		t.Fatalf("CalendarPeriod.String() returned '%s'", p.Calendar[1].String())
+	}
+}
+
+func TestKeepCalendarError(t *testing.T) {
+	c := &Config{}
+	s := &state{}
+	p := &Plan{
+		Name: "testplan",
+		conf: c,
+	}
+
+	cases := []string{"keep daily -1", "keep daily", "keep fortnightly 2", "keep monthly 0", "keep yearly 1x"}
+	for i, cc := range cases {
+		s.scanner = bufio.NewScanner(strings.NewReader(cc))
+		s.scanLine()
+
+		ret := p.keepCalendar(s)
+
+		if s.err == nil {
+			t.Fatalf("%d keepCalendar() did not return error", i)
+		}
+
+		if ret != nil {
+			t.Fatalf("%d keepCalendar() returned an action", i)
+		}
+
+		s.err = nil
+	}
+}
+
+func TestDatasets(t *testing.T) {
+	filesystems := []string{
+		"pool",
+		"pool/customers",
+		"pool/customers/a",
+		"pool/customers/a/data",
+		"pool/customers/b",
+		"pool/customers/tmp1",
+		"pool/shared",
+		"pool/shared/x",
+		"pool/shared/x/y",
+	}
+
+	cases := []struct {
+		plan     Plan
+		expected []string
+	}{
+		{Plan{Paths: []string{"pool/missing"}}, []string{"pool/missing"}},
+		{Plan{Paths: []string{"pool/customers/*"}}, []string{"pool/customers/a", "pool/customers/b", "pool/customers/tmp1"}},
+		{Plan{Paths: []string{"pool/customers/*"}, Excludes: []string{"pool/customers/tmp*"}}, []string{"pool/customers/a", "pool/customers/b"}},
+		{Plan{RecursivePaths: []string{"pool/shared"}}, []string{"pool/shared", "pool/shared/x", "pool/shared/x/y"}},
+		{Plan{RecursivePaths: []string{"pool/shared"}, Excludes: []string{"pool/shared/x"}}, []string{"pool/shared"}},
+		{Plan{Paths: []string{"pool/shared", "pool/shared/*"}, RecursivePaths: []string{"pool/shared"}}, []string{"pool/shared", "pool/shared/x", "pool/shared/x/y"}},
+		{Plan{Paths: []string{"pool/*/a"}}, []string{"pool/customers/a"}},
+	}
+
+	for i, c := range cases {
+		datasets := c.plan.Datasets(filesystems)
+		if !reflect.DeepEqual(datasets, c.expected) {
+			t.Fatalf("%d Datasets() returned %v, expected %v", i, datasets, c.expected)
+		}
+	}
+}
+
+func TestNamedDatasets(t *testing.T) {
+	plan := Plan{
+		Paths:          []string{"pool/a", "pool/customers/*", "pool/tmp"},
+		RecursivePaths: []string{"pool/shared"},
+		Excludes:       []string{"pool/tmp*"},
+	}
+
+	datasets := plan.NamedDatasets()
+	expected := []string{"pool/a", "pool/shared"}
+	if !reflect.DeepEqual(datasets, expected) {
+		t.Fatalf("NamedDatasets() returned %v, expected %v", datasets, expected)
+	}
+}
+
+func TestProtectFilePatterns(t *testing.T) {
+	f, err := ioutil.TempFile("", "zfs-cleaner-test")
+	if err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = f.WriteString("horse\nglob:release-*\nregex:pre-upgrade-[0-9]+\n")
+	if err != nil {
+		t.Fatalf("Error: %s", err.Error())
+	}
+
+	c := &Config{}
+	err = c.Read(strings.NewReader("plan buh {\npath /buh\nprotect <" + f.Name() + "\n}\n"))
+	if err != nil {
+		t.Fatalf("Read() returned error: %s", err.Error())
+	}
+
+	plan := c.Plans[0]
+	if !reflect.DeepEqual(plan.Protect, []string{"horse"}) {
+		t.Fatalf("Read() set Protect to %v", plan.Protect)
+	}
+
+	if len(plan.ProtectPatterns) != 2 || !plan.ProtectPatterns[0].Match("release-1") || !plan.ProtectPatterns[1].Match("pre-upgrade-1") {
+		t.Fatalf("Read() set ProtectPatterns to %v", plan.ProtectPatterns)
+	}
+}
+
+func TestManages(t *testing.T) {
+	regex, _, _ := parsePattern("regex:manual-[0-9]+")
+	cases := []struct {
+		plan     Plan
+		name     string
+		expected bool
+	}{
+		{Plan{}, "anything", true},
+		{Plan{Match: []Pattern{{Kind: PatternGlob, Expr: "auto-*"}}}, "auto-1", true},
+		{Plan{Match: []Pattern{{Kind: PatternGlob, Expr: "auto-*"}}}, "manual-1", false},
+		{Plan{Match: []Pattern{{Kind: PatternGlob, Expr: "auto-*"}, regex}}, "manual-1", true},
+	}
+
+	for i, c := range cases {
+		if c.plan.Manages(c.name) != c.expected {
+			t.Fatalf("%d Manages(%s) did not return %v", i, c.name, c.expected)
+		}
+	}
+}
+
+func TestCloneAllowed(t *testing.T) {
+	regex, _, _ := parsePattern("regex:pool/ci/[0-9]+")
+	allowlist := []Pattern{{Kind: PatternGlob, Expr: "pool/tmp-*"}, regex}
+	cases := []struct {
+		plan     Plan
+		clone    string
+		expected bool
+	}{
+		{Plan{}, "pool/tmp-1", false},
+		{Plan{Clones: ClonesKeep, CloneAllowlist: allowlist}, "pool/tmp-1", false},
+		{Plan{Clones: ClonesDestroy, CloneAllowlist: allowlist}, "pool/tmp-1", true},
+		{Plan{Clones: ClonesDestroy, CloneAllowlist: allowlist}, "pool/ci/12", true},
+		{Plan{Clones: ClonesDestroy, CloneAllowlist: allowlist}, "pool/ci/build", false},
+		{Plan{Clones: ClonesDestroy, CloneAllowlist: allowlist}, "pool/data", false},
+	}
+
+	for i, c := range cases {
+		if c.plan.CloneAllowed(c.clone) != c.expected {
+			t.Errorf("%d CloneAllowed(%s) did not return %v", i, c.clone, c.expected)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package conf
+
+import (
+	"bufio"
+	"strings"
+)
+
+const (
+	// PropertyPlan is the suffix of the user property naming the plan for
+	// a dataset.
+	PropertyPlan = "plan"
+
+	// PropertyKeep is the suffix of the user property holding inline keep
+	// rules for a dataset.
+	PropertyKeep = "keep"
+)
+
+// PropertyName returns the full name of the user property with suffix.
+func (c *Config) PropertyName(suffix string) string {
+	return c.Properties + ":" + suffix
+}
+
+// ParseKeepProperty returns a plan for the value of a keep property. The value
+// holds one or more keep rules without the "keep" keyword separated by
+// commas, like "latest 10, 1h for 2d". The plan will include dataset as its
+// only path.
+func ParseKeepProperty(name string, dataset string, value string) (Plan, error) {
+	lines := []string{}
+	for _, rule := range strings.Split(value, ",") {
+		lines = append(lines, keepIdentifier+" "+strings.TrimSpace(rule))
+	}
+	lines = append(lines, blockEnd)
+
+	c := &Config{}
+	p := &Plan{
+		Name:   name,
+		Paths:  []string{dataset},
+		conf:   c,
+		Latest: 1,
+	}
+
+	s := &state{}
+	s.scanner = bufio.NewScanner(strings.NewReader(strings.Join(lines, "\n")))
+
+	for a := p.planLine; a != nil; a = a(s) {
+	}
+
+	if s.err != nil {
+		return Plan{}, s.err
+	}
+
+	return c.Plans[0], nil
+}
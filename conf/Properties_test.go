@@ -0,0 +1,55 @@
+package conf
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseKeepProperty(t *testing.T) {
+	cases := []struct {
+		value    string
+		err      string
+		expected Plan
+	}{
+		{"1h for 2d", "", Plan{
+			Name:    "p",
+			Paths:   []string{"pool/a"},
+			Latest:  1,
+			Periods: []Period{{Frequency: time.Hour, Age: 48 * time.Hour}},
+		}},
+		{"latest 10, 1h for 2d,daily 14", "", Plan{
+			Name:     "p",
+			Paths:    []string{"pool/a"},
+			Latest:   10,
+			Periods:  []Period{{Frequency: time.Hour, Age: 48 * time.Hour}},
+			Calendar: []CalendarPeriod{{Unit: "daily", Count: 14}},
+		}},
+		{"2d for 1h", "frequency cannot be bigger than age", Plan{}},
+		{"1h for 2d, path /etc", "unparseable tokens: [keep path /etc]", Plan{}},
+		{"}", "unparseable tokens: [keep }]", Plan{}},
+	}
+
+	for i, c := range cases {
+		plan, err := ParseKeepProperty("p", "pool/a", c.value)
+		if err != nil && err.Error() != c.err {
+			t.Fatalf("%d ParseKeepProperty(%s) returned unexpected error: expected '%s', got '%s'", i, c.value, c.err, err.Error())
+		}
+
+		if err == nil && c.err != "" {
+			t.Fatalf("%d ParseKeepProperty(%s) did not return error '%s'", i, c.value, c.err)
+		}
+
+		if !reflect.DeepEqual(plan, c.expected) {
+			t.Fatalf("%d ParseKeepProperty(%s) returned %+v, expected %+v", i, c.value, plan, c.expected)
+		}
+	}
+}
+
+func TestPropertyName(t *testing.T) {
+	c := &Config{Properties: "cego:zfs-cleaner"}
+
+	if c.PropertyName(PropertyPlan) != "cego:zfs-cleaner:plan" {
+		t.Fatalf("PropertyName() returned %s", c.PropertyName(PropertyPlan))
+	}
+}
@@ -46,3 +46,26 @@ func parseDuration(input string) (time.Duration, error) {
 
 	return t, nil
 }
+
+// formatDuration will format d using the biggest unit understood by
+// parseDuration that represents d exactly.
+func formatDuration(d time.Duration) string {
+	units := []struct {
+		identifier string
+		size       time.Duration
+	}{
+		{"y", time.Hour * 24 * 365},
+		{"d", time.Hour * 24},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+	}
+
+	for _, unit := range units {
+		if d >= unit.size && d%unit.size == 0 {
+			return strconv.FormatInt(int64(d/unit.size), 10) + unit.identifier
+		}
+	}
+
+	return d.String()
+}
@@ -45,3 +45,36 @@ func TestParseDuration(t *testing.T) {
 		}
 	}
 }
+
+func TestFormatDuration(t *testing.T) {
+	cases := []struct {
+		in  time.Duration
+		out string
+	}{
+		{0, "0s"},
+		{time.Second, "1s"},
+		{90 * time.Second, "90s"},
+		{2 * time.Minute, "2m"},
+		{time.Hour, "1h"},
+		{36 * time.Hour, "36h"},
+		{48 * time.Hour, "2d"},
+		{365 * 24 * time.Hour, "1y"},
+		{time.Millisecond, "1ms"},
+	}
+
+	for i, c := range cases {
+		out := formatDuration(c.in)
+		if out != c.out {
+			t.Fatalf("%d Got unexpected output from %d: expected '%s', got '%s'", i, c.in, c.out, out)
+		}
+
+		if c.in < time.Second {
+			continue
+		}
+
+		back, err := parseDuration(out)
+		if err != nil || back != c.in {
+			t.Fatalf("%d formatDuration() output '%s' did not parse back to %s", i, out, c.in)
+		}
+	}
+}
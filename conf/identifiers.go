@@ -1,18 +1,95 @@
 package conf
 
 const (
-	planIdentifier    = "plan"
-	keepIdentifier    = "keep"
-	pathIdentifier    = "path"
-	protectIdentifier = "protect"
-	includeIdentifier = "include"
+	planIdentifier     = "plan"
+	keepIdentifier     = "keep"
+	pathIdentifier     = "path"
+	protectIdentifier  = "protect"
+	includeIdentifier  = "include"
+	intervalIdentifier = "interval"
+	jitterIdentifier   = "jitter"
+	timezoneIdentifier = "timezone"
+	replicaIdentifier  = "replica"
+	excludeIdentifier  = "exclude"
+	matchIdentifier    = "match"
+	classIdentifier    = "class"
+	anchorIdentifier   = "anchor"
+	clonesIdentifier   = "clones"
+
+	pauseIfStaleIdentifier = "pause-if-stale"
+	expectEveryIdentifier  = "expect-every"
+
+	targetFreeIdentifier       = "target-free"
+	maxSnapshotUsageIdentifier = "max-snapshot-usage"
+
+	maxDestroyIdentifier        = "max-destroy"
+	maxDestroyPercentIdentifier = "max-destroy-percent"
+	propertiesIdentifier        = "properties"
+	overlapIdentifier           = "overlap"
+
+	retryAttemptsIdentifier = "retry-attempts"
+	retryBackoffIdentifier  = "retry-backoff"
+	retryMaxWaitIdentifier  = "retry-max-wait"
 )
 
 const (
 	keepFor    = "for"
 	keepLatest = "latest"
+
+	pathRecursive = "recursive"
+)
+
+const (
+	// AnchorNow measures periods from the time of the run.
+	AnchorNow = "now"
+
+	// AnchorNewest measures periods from the newest snapshot of each
+	// dataset.
+	AnchorNewest = "newest"
+)
+
+const (
+	// ClonesKeep keeps snapshots with clones and reports them.
+	ClonesKeep = "keep"
+
+	// ClonesError refuses to clean a dataset with snapshots that would
+	// be destroyed if not for their clones.
+	ClonesError = "error"
+
+	// ClonesDestroy destroys snapshots along with their clones, if the
+	// clones are allowed.
+	ClonesDestroy = "destroy"
+)
+
+const (
+	// OverlapError refuses to run when a dataset is in more than one plan.
+	OverlapError = "error"
+
+	// OverlapUnion keeps a snapshot if any plan for the dataset keeps it.
+	OverlapUnion = "union"
 )
 
+const (
+	// ReplicaFile reads snapshot names from a file.
+	ReplicaFile = "file"
+
+	// ReplicaCommand reads snapshot names from the output of a command.
+	ReplicaCommand = "command"
+
+	// ReplicaDataset uses the snapshots of a local dataset.
+	ReplicaDataset = "dataset"
+)
+
+// calendarUnits is the units accepted for calendar keeps, as in "keep daily
+// 14".
+var calendarUnits = map[string]bool{
+	"hourly":  true,
+	"daily":   true,
+	"weekly":  true,
+	"monthly": true,
+	"yearly":  true,
+}
+
 const (
 	blockStart = "{"
 	blockEnd   = "}"
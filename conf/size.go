@@ -0,0 +1,59 @@
+package conf
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	ErrSizeTooShort = Error("size string too short")
+	ErrBadPercent   = Error("percentage must be between 1% and 99%")
+)
+
+// parseSize parses a size like "500G" or "2T". Units are powers of 1024, as
+// used by zfs. A size without a unit is in bytes.
+func parseSize(input string) (uint64, error) {
+	units := map[string]uint64{
+		"K": 1 << 10,
+		"M": 1 << 20,
+		"G": 1 << 30,
+		"T": 1 << 40,
+		"P": 1 << 50,
+	}
+
+	if len(input) < 1 {
+		return 0, ErrSizeTooShort
+	}
+
+	unitSize := uint64(1)
+	unitIdentifier := strings.ToUpper(input[len(input)-1:])
+	if size, found := units[unitIdentifier]; found {
+		unitSize = size
+		input = input[:len(input)-1]
+	}
+
+	value, err := strconv.ParseFloat(input, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if value < 0 {
+		return 0, ErrNegativeNotAllowed
+	}
+
+	return uint64(value * float64(unitSize)), nil
+}
+
+// parsePercent parses a percentage like "20%".
+func parsePercent(input string) (int, error) {
+	if !strings.HasSuffix(input, "%") {
+		return 0, ErrBadPercent
+	}
+
+	value, err := strconv.Atoi(strings.TrimSuffix(input, "%"))
+	if err != nil || value < 1 || value > 99 {
+		return 0, ErrBadPercent
+	}
+
+	return value, nil
+}
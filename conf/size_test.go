@@ -0,0 +1,61 @@
+package conf
+
+import (
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected uint64
+		err      bool
+	}{
+		{"1024", 1024, false},
+		{"1K", 1024, false},
+		{"2T", 2 << 40, false},
+		{"500g", 500 << 30, false},
+		{"1.5M", 3 << 19, false},
+		{"", 0, true},
+		{"T", 0, true},
+		{"-1G", 0, true},
+		{"1X", 0, true},
+	}
+
+	for i, c := range cases {
+		size, err := parseSize(c.in)
+		if (err != nil) != c.err {
+			t.Fatalf("%d parseSize(%s) returned unexpected error: %v", i, c.in, err)
+		}
+
+		if size != c.expected {
+			t.Fatalf("%d parseSize(%s) returned %d, expected %d", i, c.in, size, c.expected)
+		}
+	}
+}
+
+func TestParsePercent(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected int
+		err      bool
+	}{
+		{"20%", 20, false},
+		{"1%", 1, false},
+		{"99%", 99, false},
+		{"20", 0, true},
+		{"0%", 0, true},
+		{"100%", 0, true},
+		{"x%", 0, true},
+	}
+
+	for i, c := range cases {
+		percent, err := parsePercent(c.in)
+		if (err != nil) != c.err {
+			t.Fatalf("%d parsePercent(%s) returned unexpected error: %v", i, c.in, err)
+		}
+
+		if percent != c.expected {
+			t.Fatalf("%d parsePercent(%s) returned %d, expected %d", i, c.in, percent, c.expected)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+	"github.com/cego/zfs-cleaner/zfs"
+	"github.com/spf13/cobra"
+)
+
+type (
+	// scheduledPlan keeps track of when a plan should run next.
+	scheduledPlan struct {
+		plan     conf.Plan
+		interval time.Duration
+		next     time.Time
+	}
+
+	// schedule decides when to run each plan in daemon mode.
+	schedule []*scheduledPlan
+)
+
+// jitterSource is used for randomizing run times.
+var jitterSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+func AddDaemonCommand(zfsExecutor zfs.Executor) {
+	fallback := time.Hour
+	listen := ""
+	daemonCmd := &cobra.Command{
+		Use:   "daemon [config file]",
+		Short: "Keep running and clean on a schedule derived from the plans",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("%s /path/to/config.conf", cmd.Name())
+			}
+			config, unlock, err := lockConfig(args[0])
+			if err != nil {
+				return err
+			}
+			// The lock is held for as long as the daemon runs. This
+			// will keep clean from running concurrently.
+			defer unlock()
+			if err := zfsExecutor.HasZFSCommand(); err != nil {
+				return err
+			}
+			// Properties may assign datasets to plans built from keep
+			// rules, these are scheduled like any other plan.
+			plans, _, _, err := resolvePlans(zfsExecutor, config, false)
+			if err != nil {
+				return err
+			}
+			s, err := newSchedule(plans, fallback, time.Now())
+			if err != nil {
+				return err
+			}
+			if listen != "" {
+				go serveMetrics(listen)
+			}
+			stop := make(chan os.Signal, 1)
+			signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+			defer signal.Stop(stop)
+			runDaemon(zfsExecutor, args[0], config, s, fallback, stop)
+			return nil
+		},
+	}
+	daemonCmd.Flags().DurationVar(&fallback, "interval", time.Hour, "Interval for plans without keep periods or an interval directive")
+	daemonCmd.Flags().StringVar(&listen, "metrics-listen", "", "Serve Prometheus metrics on /metrics at this address, for example ':9720'")
+	rootCmd.AddCommand(daemonCmd)
+}
+
+// serveMetrics will serve metrics over HTTP on addr. Errors are reported, but
+// will not stop the daemon.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		reportError("", fmt.Errorf("metrics: %s", err.Error()))
+	}
+}
+
+// newSchedule will schedule plans to run at start.
+func newSchedule(plans []conf.Plan, fallback time.Duration, start time.Time) (schedule, error) {
+	if len(plans) == 0 {
+		return nil, fmt.Errorf("no plans to schedule")
+	}
+	s, errs := schedule{}.update(plans, fallback, start)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return s, nil
+}
+
+// update returns s with plans not already in s scheduled to run at t, and
+// plans of s no longer in plans removed. Plans that cannot be scheduled are
+// left out and returned as errors.
+func (s schedule) update(plans []conf.Plan, fallback time.Duration, t time.Time) (schedule, []error) {
+	scheduled := make(map[string]*scheduledPlan)
+	for _, p := range s {
+		scheduled[p.plan.Name] = p
+	}
+	updated := schedule{}
+	errs := []error{}
+	for _, plan := range plans {
+		if p, found := scheduled[plan.Name]; found {
+			updated = append(updated, p)
+			continue
+		}
+		interval := plan.RunInterval(fallback)
+		if interval <= 0 {
+			errs = append(errs, fmt.Errorf("plan %s: interval must be positive", plan.Name))
+			continue
+		}
+		if plan.Jitter >= interval {
+			errs = append(errs, fmt.Errorf("plan %s: %s", plan.Name, conf.ErrJitterTooBig))
+			continue
+		}
+		updated = append(updated, &scheduledPlan{
+			plan:     plan,
+			interval: interval,
+			next:     t,
+		})
+	}
+	return updated, errs
+}
+
+// next returns the time of the next scheduled run.
+func (s schedule) next() time.Time {
+	next := s[0].next
+	for _, p := range s[1:] {
+		if p.next.Before(next) {
+			next = p.next
+		}
+	}
+	return next
+}
+
+// due returns all plans scheduled to run at or before t, and reschedules
+// them. Jitter will only ever move the next run closer to t, we never want
+// to run less often than the interval.
+func (s schedule) due(t time.Time) []conf.Plan {
+	plans := []conf.Plan{}
+	for _, p := range s {
+		if p.next.After(t) {
+			continue
+		}
+		plans = append(plans, p.plan)
+		var jitter time.Duration
+		if p.plan.Jitter > 0 {
+			jitter = time.Duration(jitterSource.Int63n(int64(p.plan.Jitter)))
+		}
+		p.next = t.Add(p.interval - jitter)
+	}
+	return plans
+}
+
+// runDaemon will clean according to s until something is received on stop.
+// Plans are resolved again on every run, and at least every fallback, so
+// plans assigned by properties are picked up. Errors are reported, but will
+// not stop the daemon.
+func runDaemon(zfsExecutor zfs.Executor, configPath string, config *conf.Config, s schedule, fallback time.Duration, stop <-chan os.Signal) {
+	for {
+		wait := fallback
+		if len(s) > 0 {
+			if until := time.Until(s.next()); until < wait {
+				wait = until
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		now = time.Now()
+		s = runDue(zfsExecutor, configPath, config, s, fallback, now)
+		flushOutput()
+	}
+}
+
+// runDue will resolve the plans of config, update s accordingly and clean
+// according to the plans due at t. The updated schedule is returned. Errors
+// are reported.
+func runDue(zfsExecutor zfs.Executor, configPath string, config *conf.Config, s schedule, fallback time.Duration, t time.Time) schedule {
+	plans, resolved, _, err := resolvePlans(zfsExecutor, config, false)
+	if err != nil {
+		reportError("", err)
+		return s
+	}
+	s, errs := s.update(plans, fallback, t)
+	for _, err := range errs {
+		reportError("", err)
+	}
+	due := s.due(t)
+	if len(due) == 0 {
+		return s
+	}
+	// Global settings apply, but only to the plans due.
+	err = cleanSelected(zfsExecutor, configPath, config, withOverlapping(plans, resolved, due))
+	if err != nil {
+		reportError("", err)
+	}
+	return s
+}
+
+// withOverlapping returns the names of the plans in all that are in plans, or
+// share a dataset with one of them, directly or through other plans. resolved
+// is the datasets of each plan in all. Plans sharing a dataset must always be
+// evaluated together, or the overlap policy would not apply.
+func withOverlapping(all []conf.Plan, resolved [][]string, plans []conf.Plan) map[string]bool {
+	selected := make(map[string]bool)
+	for _, plan := range plans {
+		selected[plan.Name] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		datasets := make(map[string]bool)
+		for i, plan := range all {
+			if selected[plan.Name] {
+				for _, dataset := range resolved[i] {
+					datasets[dataset] = true
+				}
+			}
+		}
+		for i, plan := range all {
+			if selected[plan.Name] {
+				continue
+			}
+			for _, dataset := range resolved[i] {
+				if datasets[dataset] {
+					selected[plan.Name] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return selected
+}
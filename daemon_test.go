@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+)
+
+func TestSchedule(t *testing.T) {
+	start := time.Unix(1492993419, 0)
+	plans := []conf.Plan{
+		{
+			Name:    "hourly",
+			Periods: []conf.Period{{Frequency: time.Hour, Age: 24 * time.Hour}},
+		},
+		{
+			Name:     "fixed",
+			Interval: 10 * time.Minute,
+			Jitter:   time.Minute,
+		},
+	}
+
+	s, err := newSchedule(plans, time.Hour, start)
+	if err != nil {
+		t.Fatalf("newSchedule() returned error: %s", err.Error())
+	}
+
+	if !s.next().Equal(start) {
+		t.Fatalf("newSchedule() did not schedule plans to run at start")
+	}
+
+	plans = s.due(start)
+	if len(plans) != 2 {
+		t.Fatalf("due() returned %d plans at start, expected 2", len(plans))
+	}
+
+	// The fixed plan should be scheduled between 9 and 10 minutes after
+	// start because of jitter.
+	next := s.next()
+	if next.Before(start.Add(9*time.Minute)) || next.After(start.Add(10*time.Minute)) {
+		t.Fatalf("next() returned %s, expected 9-10 minutes after %s", next, start)
+	}
+
+	plans = s.due(next)
+	if len(plans) != 1 || plans[0].Name != "fixed" {
+		t.Fatalf("due() returned wrong plans: %+v", plans)
+	}
+
+	// The hourly plan must run every 30 minutes.
+	plans = s.due(start.Add(30*time.Minute - time.Second))
+	for _, plan := range plans {
+		if plan.Name == "hourly" {
+			t.Fatalf("due() returned hourly plan before 30 minutes")
+		}
+	}
+
+	plans = s.due(start.Add(30 * time.Minute))
+	if len(plans) != 1 || plans[0].Name != "hourly" {
+		t.Fatalf("due() did not return hourly plan after 30 minutes: %+v", plans)
+	}
+}
+
+func TestScheduleError(t *testing.T) {
+	cases := [][]conf.Plan{
+		{},
+		{{Name: "jitter", Jitter: time.Hour}},
+	}
+
+	for i, plans := range cases {
+		_, err := newSchedule(plans, time.Hour, time.Now())
+		if err == nil {
+			t.Fatalf("%d newSchedule() did not return error", i)
+		}
+	}
+}
+
+func TestRunDaemonStop(t *testing.T) {
+	s := schedule{
+		{
+			plan:     conf.Plan{Name: "future"},
+			interval: time.Hour,
+			next:     time.Now().Add(time.Hour),
+		},
+	}
+
+	stop := make(chan os.Signal, 1)
+	stop <- os.Interrupt
+
+	done := make(chan struct{})
+	go func() {
+		runDaemon(&testExecutor{}, "test.conf", &conf.Config{}, s, time.Hour, stop)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("runDaemon() did not stop")
+	}
+}
+
+func TestRunDueOverlap(t *testing.T) {
+	start := time.Unix(1492993419, 0)
+	now = start
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@a	1492989570
+playground/fs1@b	1492989571
+playground/fs1@c	1492989572
+playground/fs2@a	1492989570
+playground/fs2@b	1492989571
+`),
+	}
+	config := &conf.Config{
+		Overlap: conf.OverlapUnion,
+		Plans: []conf.Plan{
+			{Name: "a", Paths: []string{"playground/fs1"}, Latest: 1, Interval: 10 * time.Minute},
+			{Name: "b", Paths: []string{"playground/fs1", "playground/fs2"}, Latest: 3, Interval: time.Hour},
+			{Name: "c", Paths: []string{"playground/fs2"}, Latest: 1, Interval: time.Hour},
+			{Name: "d", Paths: []string{"playground/fs3"}, Latest: 1, Interval: time.Hour},
+		},
+	}
+
+	s, err := newSchedule(config.Plans, time.Hour, start)
+	if err != nil {
+		t.Fatalf("newSchedule() returned error: %s", err.Error())
+	}
+	s.due(start)
+
+	// Only a is due, but b and c share datasets with it.
+	all, resolved, _, err := resolvePlans(zfsTestExecutor, config, false)
+	if err != nil {
+		t.Fatalf("resolvePlans() returned error: %s", err.Error())
+	}
+	selected := withOverlapping(all, resolved, s.due(start.Add(10*time.Minute)))
+	expected := map[string]bool{"a": true, "b": true, "c": true}
+	if !reflect.DeepEqual(selected, expected) {
+		t.Fatalf("withOverlapping() returned %v, expected %v", selected, expected)
+	}
+
+	withOutput(outputText, func(buffer *bytes.Buffer) {
+		runDue(zfsTestExecutor, "test.conf", config, s, time.Hour, start.Add(20*time.Minute))
+	})
+	if len(zfsTestExecutor.destroyed) != 0 {
+		t.Fatalf("runDue() destroyed %v kept by an overlapping plan", zfsTestExecutor.destroyed)
+	}
+}
+
+func TestRunDueProperties(t *testing.T) {
+	start := time.Unix(1492993419, 0)
+	now = start
+	zfsTestExecutor := &testExecutor{
+		properties: []byte("pool/a\tcego:zfs-cleaner:keep\tlatest 1, 1h for 1d\tlocal\n" +
+			"pool/b\tcego:zfs-cleaner:keep\tlatest 1\tlocal\n"),
+		getSnapshotListResult: []byte(`pool/a@a	1492989570
+pool/a@b	1492989571
+pool/b@a	1492989570
+pool/b@b	1492989571
+`),
+	}
+	// Without any plans in the configuration, plans from keep properties
+	// must be scheduled.
+	config := &conf.Config{Properties: "cego:zfs-cleaner"}
+
+	plans, _, _, err := resolvePlans(zfsTestExecutor, config, false)
+	if err != nil {
+		t.Fatalf("resolvePlans() returned error: %s", err.Error())
+	}
+	s, err := newSchedule(plans, 2*time.Hour, start)
+	if err != nil {
+		t.Fatalf("newSchedule() returned error: %s", err.Error())
+	}
+
+	withOutput(outputText, func(buffer *bytes.Buffer) {
+		s = runDue(zfsTestExecutor, "test.conf", config, s, 2*time.Hour, start)
+	})
+	expected := []string{"pool/b@a"}
+	if !reflect.DeepEqual(zfsTestExecutor.destroyed, expected) {
+		t.Fatalf("runDue() destroyed %v, expected %v", zfsTestExecutor.destroyed, expected)
+	}
+
+	// The plan keeping hourly snapshots runs every 30 minutes, the plan
+	// keeping only the latest every 2 hours.
+	zfsTestExecutor.destroyed = nil
+	zfsTestExecutor.getSnapshotListResult = []byte(`pool/a@a	1492989570
+pool/a@b	1492989571
+pool/a@c	1492993420
+pool/a@d	1492993421
+pool/b@b	1492989571
+pool/b@c	1492993420
+`)
+	withOutput(outputText, func(buffer *bytes.Buffer) {
+		s = runDue(zfsTestExecutor, "test.conf", config, s, 2*time.Hour, start.Add(30*time.Minute))
+	})
+	expected = []string{"pool/a@b"}
+	if !reflect.DeepEqual(zfsTestExecutor.destroyed, expected) {
+		t.Fatalf("runDue() destroyed %v, expected %v", zfsTestExecutor.destroyed, expected)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cego/zfs-cleaner/zfs"
+	"github.com/spf13/cobra"
+)
+
+func AddExplainCommand(zfsExecutor zfs.Executor) {
+	explainCmd := &cobra.Command{
+		Use:   "explain [config file] [dataset]",
+		Short: "Print why each snapshot would be kept or destroyed and exit",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 || len(args) > 2 {
+				return fmt.Errorf("%s /path/to/config.conf [dataset]", cmd.Name())
+			}
+			config, err := loadConfig(args[0])
+			if err != nil {
+				return err
+			}
+			if err := zfsExecutor.HasZFSCommand(); err != nil {
+				return err
+			}
+			lists, err := processAll(now, config, zfsExecutor)
+			if err != nil {
+				return err
+			}
+			dataset := ""
+			if len(args) == 2 {
+				dataset = args[1]
+			}
+			return explain(stdout, lists, dataset)
+		},
+	}
+	rootCmd.AddCommand(explainCmd)
+}
+
+// explain writes the keep decision and the reasons behind it for every
+// snapshot in lists. If dataset is non-empty, only that dataset is included.
+func explain(w io.Writer, lists []datasetList, dataset string) error {
+	found := false
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, list := range lists {
+		if dataset != "" && list.dataset != dataset {
+			continue
+		}
+		found = true
+		if structuredOutput() {
+			for _, snapshot := range list.snapshots {
+				action := actionDestroy
+				if snapshot.Keep {
+					action = actionKeep
+				}
+				emit(listRecord(action, list, snapshot))
+			}
+			for _, snapshot := range list.unmanaged {
+				emit(listRecord(actionUnmanaged, list, snapshot))
+			}
+			continue
+		}
+		fmt.Fprintf(tw, "%s (plan %s, anchor %s %s)\n", list.dataset, list.planName(), list.anchorName(), list.anchor.Format(time.RFC3339))
+		for _, snapshot := range list.snapshots {
+			action := "destroy"
+			if snapshot.Keep {
+				action = "keep"
+			}
+			reasons := make([]string, len(snapshot.Reasons))
+			for i, reason := range snapshot.Reasons {
+				reasons[i] = reason.String()
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", snapshot.SnapshotName(), now.Sub(snapshot.Creation), action, strings.Join(reasons, "; "))
+		}
+		for _, snapshot := range list.unmanaged {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t\n", snapshot.SnapshotName(), now.Sub(snapshot.Creation), actionUnmanaged)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if dataset != "" && !found {
+		return fmt.Errorf("no plan found for dataset '%s'", dataset)
+	}
+	return nil
+}
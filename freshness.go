@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+	"github.com/cego/zfs-cleaner/zfs"
+	"github.com/spf13/cobra"
+)
+
+// Nagios plugin exit codes.
+const (
+	nagiosOK = iota
+	nagiosWarning
+	nagiosCritical
+	nagiosUnknown
+)
+
+var nagiosStatus = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// nagiosResult is returned by check-freshness when the status is not OK. The
+// status has already been written, and should be used as the exit status.
+type nagiosResult struct {
+	status int
+}
+
+// Error implements error.
+func (e *nagiosResult) Error() string {
+	return fmt.Sprintf("freshness %s", nagiosStatus[e.status])
+}
+
+// freshnessProblem is a single problem found by check-freshness.
+type freshnessProblem struct {
+	status  int
+	dataset string
+	message string
+}
+
+func AddCheckFreshnessCommand(zfsExecutor zfs.Executor) {
+	checkFreshnessCmd := &cobra.Command{
+		Use:   "check-freshness [config file]",
+		Short: "Check that all planned datasets have recent snapshots, Nagios style",
+		// The status line is the only output Nagios will parse.
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Nagios expects UNKNOWN for usage errors.
+			status := nagiosUnknown
+			if len(args) != 1 {
+				writeFreshnessStatus(stdout, status, fmt.Sprintf("usage: %s /path/to/config.conf", cmd.Name()))
+			} else {
+				status = runCheckFreshness(stdout, zfsExecutor, args[0])
+			}
+			if status != nagiosOK {
+				return &nagiosResult{status: status}
+			}
+			return nil
+		},
+	}
+	rootCmd.AddCommand(checkFreshnessCmd)
+}
+
+// runCheckFreshness checks all datasets planned in the configuration at
+// path, and returns the Nagios status.
+func runCheckFreshness(w io.Writer, zfsExecutor zfs.Executor, path string) int {
+	config, err := loadConfig(path)
+	if err == nil {
+		err = zfsExecutor.HasZFSCommand()
+	}
+	var lists []datasetList
+	if err == nil {
+		// Space targets are left out. They can only make the history
+		// shorter, and would require more zfs calls.
+		lists, err = evaluateAll(now, config, zfsExecutor, nil)
+	}
+	if err != nil {
+		writeFreshnessStatus(w, nagiosUnknown, err.Error())
+		return nagiosUnknown
+	}
+	return checkFreshness(w, lists)
+}
+
+// checkFreshness writes a Nagios status line for lists, followed by a line
+// per problem found. The Nagios status is returned.
+func checkFreshness(w io.Writer, lists []datasetList) int {
+	status := nagiosOK
+	problems := []freshnessProblem{}
+	counts := make([]int, len(nagiosStatus))
+	for _, list := range lists {
+		found := listFreshness(list)
+		worst := nagiosOK
+		for _, problem := range found {
+			if problem.status > worst {
+				worst = problem.status
+			}
+		}
+		counts[worst]++
+		if worst > status {
+			status = worst
+		}
+		problems = append(problems, found...)
+	}
+	summary := []string{}
+	for _, s := range []int{nagiosCritical, nagiosWarning, nagiosOK} {
+		if counts[s] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[s], strings.ToLower(nagiosStatus[s])))
+		}
+	}
+	if len(summary) == 0 {
+		summary = append(summary, "no datasets planned")
+	}
+	writeFreshnessStatus(w, status, strings.Join(summary, ", "))
+	for _, problem := range problems {
+		if structuredOutput() {
+			emit(record{
+				Action:  actionFreshness,
+				Dataset: problem.dataset,
+				Status:  nagiosStatus[problem.status],
+				Message: problem.message,
+			})
+			continue
+		}
+		fmt.Fprintf(w, "%s: %s: %s\n", nagiosStatus[problem.status], problem.dataset, problem.message)
+	}
+	return status
+}
+
+// writeFreshnessStatus writes the Nagios status line. When output is
+// structured, a record without a dataset is emitted instead.
+func writeFreshnessStatus(w io.Writer, status int, message string) {
+	if structuredOutput() {
+		emit(record{
+			Action:  actionFreshness,
+			Status:  nagiosStatus[status],
+			Message: message,
+		})
+		return
+	}
+	fmt.Fprintf(w, "FRESHNESS %s - %s\n", nagiosStatus[status], message)
+}
+
+// listFreshness returns all problems with the snapshots in list. The newest
+// snapshot must be younger than expect-every, and every keep period and
+// calendar keep must hold the snapshots expected.
+func listFreshness(list datasetList) []freshnessProblem {
+	problems := []freshnessProblem{}
+	add := func(status int, format string, args ...interface{}) {
+		problems = append(problems, freshnessProblem{
+			status:  status,
+			dataset: list.dataset,
+			message: fmt.Sprintf(format, args...),
+		})
+	}
+	if list.err != nil {
+		add(nagiosUnknown, "%s", list.err.Error())
+		return problems
+	}
+	expect := list.plan.ExpectEvery
+	latest := list.snapshots.Latest()
+	if expect > 0 {
+		switch {
+		case latest == nil:
+			add(nagiosCritical, "no snapshots, expected every %s", expect)
+		case now.Sub(latest.Creation) > 2*expect:
+			add(nagiosCritical, "newest snapshot %s is %s old, expected every %s", latest.Name, now.Sub(latest.Creation), expect)
+		case now.Sub(latest.Creation) > expect:
+			add(nagiosWarning, "newest snapshot %s is %s old, expected every %s", latest.Name, now.Sub(latest.Creation), expect)
+		}
+	}
+	location := list.plan.Location
+	if location == nil {
+		location = time.Local
+	}
+	// Check periods the same way processAll applies them.
+	rest := list.snapshots
+	for _, class := range list.plan.Classes {
+		var members zfs.SnapshotList
+		members, rest = rest.Split(func(snapshot *zfs.Snapshot) bool {
+			return class.Match.Match(snapshot.SnapshotName())
+		})
+		prefix := fmt.Sprintf("class %s: ", class.Name)
+		for _, message := range periodGaps(list.anchor, members, class.Periods, prefix) {
+			add(nagiosWarning, "%s", message)
+		}
+		for _, message := range calendarGaps(list.anchor, members, class.Calendar, location, prefix) {
+			add(nagiosWarning, "%s", message)
+		}
+	}
+	for _, message := range periodGaps(list.anchor, rest, list.plan.Periods, "") {
+		add(nagiosWarning, "%s", message)
+	}
+	for _, message := range calendarGaps(list.anchor, rest, list.plan.Calendar, location, "") {
+		add(nagiosWarning, "%s", message)
+	}
+	return problems
+}
+
+// periodGaps returns a message for every period not holding the number of
+// snapshots expected. Only history since the oldest snapshot in list is
+// expected, and one slot is allowed to be missing at each end of the period.
+func periodGaps(anchor time.Time, list zfs.SnapshotList, periods []conf.Period, prefix string) []string {
+	messages := []string{}
+	oldest := list.Oldest()
+	if oldest == nil {
+		return messages
+	}
+	for _, period := range periods {
+		// Frequencies below a second will keep everything.
+		if period.Frequency < time.Second {
+			continue
+		}
+		span := anchor.Sub(oldest.Creation)
+		if span > period.Age {
+			span = period.Age
+		}
+		expected := int(span/period.Frequency) - 1
+		if expected <= 0 {
+			continue
+		}
+		rule := prefix + period.String()
+		held := 0
+		for _, snapshot := range list {
+			for _, reason := range snapshot.Reasons {
+				if reason.Kind == zfs.ReasonPeriod && strings.HasPrefix(reason.Detail, rule+", ") {
+					held++
+					break
+				}
+			}
+		}
+		if held < expected {
+			messages = append(messages, fmt.Sprintf("%s holds %d snapshots, expected at least %d", rule, held, expected))
+		}
+	}
+	return messages
+}
+
+// calendarGaps returns a message for every calendar keep not holding the
+// number of snapshots expected. Only buckets starting after the oldest
+// snapshot in list are expected, and the bucket containing anchor is allowed
+// to be empty.
+func calendarGaps(anchor time.Time, list zfs.SnapshotList, calendar []conf.CalendarPeriod, location *time.Location, prefix string) []string {
+	messages := []string{}
+	oldest := list.Oldest()
+	if oldest == nil {
+		return messages
+	}
+	for _, c := range calendar {
+		expected := 0
+		for i := 1; i < c.Count; i++ {
+			if zfs.CalendarUnit(c.Unit).BucketStart(anchor, location, i).Before(oldest.Creation) {
+				break
+			}
+			expected++
+		}
+		if expected == 0 {
+			continue
+		}
+		rule := prefix + c.String()
+		held := 0
+		for _, snapshot := range list {
+			for _, reason := range snapshot.Reasons {
+				if reason.Kind == zfs.ReasonCalendar && strings.HasPrefix(reason.Detail, rule+", ") {
+					held++
+					break
+				}
+			}
+		}
+		if held < expected {
+			messages = append(messages, fmt.Sprintf("%s holds %d snapshots, expected at least %d", rule, held, expected))
+		}
+	}
+	return messages
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+	"github.com/cego/zfs-cleaner/zfs"
+)
+
+func TestCheckFreshness(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	output := ""
+	for i := 9; i >= 0; i-- {
+		creation := now.Add(-30*time.Minute - time.Duration(i)*time.Hour)
+		output += fmt.Sprintf("playground/fresh@snap%d\t%d\n", 9-i, creation.Unix())
+	}
+	output += fmt.Sprintf("playground/gappy@snap0\t%d\n", now.Add(-9*time.Hour-30*time.Minute).Unix())
+	output += fmt.Sprintf("playground/gappy@snap1\t%d\n", now.Add(-30*time.Minute).Unix())
+	output += fmt.Sprintf("playground/stale@snap0\t%d\n", now.Add(-13*time.Hour).Unix())
+
+	zfsTestExecutor := &testExecutor{getSnapshotListResult: []byte(output)}
+	period := []conf.Period{{Frequency: time.Hour, Age: 24 * time.Hour}}
+	hourly := []conf.CalendarPeriod{{Unit: "hourly", Count: 10}}
+
+	cases := []struct {
+		plans    []conf.Plan
+		status   int
+		expected string
+	}{
+		{
+			[]conf.Plan{{Name: "a", Paths: []string{"playground/fresh"}, Latest: 1, Periods: period, ExpectEvery: time.Hour}},
+			nagiosOK,
+			"FRESHNESS OK - 1 ok\n",
+		},
+		{
+			[]conf.Plan{{Name: "a", Paths: []string{"playground/fresh", "playground/gappy"}, Latest: 1, Periods: period, ExpectEvery: time.Hour}},
+			nagiosWarning,
+			"FRESHNESS WARNING - 1 warning, 1 ok\nWARNING: playground/gappy: keep 1h for 1d holds 2 snapshots, expected at least 8\n",
+		},
+		{
+			[]conf.Plan{{Name: "a", Paths: []string{"playground/fresh", "playground/gappy"}, Latest: 1, Calendar: hourly}},
+			nagiosWarning,
+			"FRESHNESS WARNING - 1 warning, 1 ok\nWARNING: playground/gappy: keep hourly 10 holds 1 snapshots, expected at least 9\n",
+		},
+		{
+			[]conf.Plan{{
+				Name:    "a",
+				Paths:   []string{"playground/gappy"},
+				Latest:  1,
+				Classes: []conf.Class{{Name: "all", Match: conf.Pattern{Kind: conf.PatternGlob, Expr: "*"}, Calendar: hourly}},
+			}},
+			nagiosWarning,
+			"FRESHNESS WARNING - 1 warning\nWARNING: playground/gappy: class all: keep hourly 10 holds 1 snapshots, expected at least 9\n",
+		},
+		{
+			[]conf.Plan{
+				{Name: "a", Paths: []string{"playground/fresh"}, Latest: 1, ExpectEvery: time.Hour},
+				{Name: "b", Paths: []string{"playground/stale", "playground/missing"}, Latest: 1, ExpectEvery: 6 * time.Hour},
+			},
+			nagiosCritical,
+			"FRESHNESS CRITICAL - 2 critical, 1 ok\nCRITICAL: playground/stale: newest snapshot playground/stale@snap0 is 13h0m0s old, expected every 6h0m0s\nCRITICAL: playground/missing: no snapshots, expected every 6h0m0s\n",
+		},
+		{
+			[]conf.Plan{{Name: "a", Paths: []string{"playground/stale"}, Latest: 1, ExpectEvery: 10 * time.Hour}},
+			nagiosWarning,
+			"FRESHNESS WARNING - 1 warning\nWARNING: playground/stale: newest snapshot playground/stale@snap0 is 13h0m0s old, expected every 10h0m0s\n",
+		},
+		{
+			nil,
+			nagiosOK,
+			"FRESHNESS OK - no datasets planned\n",
+		},
+	}
+
+	for i, c := range cases {
+		lists, err := processAll(now, &conf.Config{Plans: c.plans}, zfsTestExecutor)
+		if err != nil {
+			t.Fatalf("%d processAll() returned error: %s", i, err.Error())
+		}
+
+		buffer := &bytes.Buffer{}
+		status := checkFreshness(buffer, lists)
+		if status != c.status {
+			t.Errorf("%d checkFreshness() returned %d, expected %d", i, status, c.status)
+		}
+
+		if buffer.String() != c.expected {
+			t.Errorf("%d checkFreshness() printed:\n%s\nexpected:\n%s", i, buffer.String(), c.expected)
+		}
+	}
+}
+
+func TestRunCheckFreshnessUnknown(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	status := runCheckFreshness(buffer, &testExecutor{}, "/nonexisting.conf")
+	if status != nagiosUnknown {
+		t.Fatalf("runCheckFreshness() returned %d, expected %d", status, nagiosUnknown)
+	}
+}
+
+func TestRunCheckFreshnessSpaceTarget(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	f, err := ioutil.TempFile("", "zfs-cleaner-test")
+	if err != nil {
+		t.Fatalf("Failed to create config: %s", err.Error())
+	}
+	defer os.Remove(f.Name())
+	_, _ = f.WriteString("plan a {\n\tpath playground/fs1\n\tkeep latest 1\n\tkeep 1h for 1d\n\ttarget-free 90%\n}\n")
+	f.Close()
+
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(fmt.Sprintf("playground/fs1@snap1\t%d\t0\t1\t1000\nplayground/fs1@snap2\t%d\t0\t2\t1000\n", now.Add(-2*time.Hour).Unix(), now.Add(-30*time.Minute).Unix())),
+		pools:                 map[string]zfs.PoolSpace{"playground": {Free: 10, Size: 100}},
+	}
+
+	withOutput(outputText, func(buffer *bytes.Buffer) {
+		status := runCheckFreshness(buffer, zfsTestExecutor, f.Name())
+		if status != nagiosOK || buffer.String() != "FRESHNESS OK - 1 ok\n" {
+			t.Fatalf("runCheckFreshness() returned %d and printed:\n%s", status, buffer.String())
+		}
+	})
+}
+
+func TestCheckFreshnessJSON(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(fmt.Sprintf("playground/stale@snap0\t%d\n", now.Add(-13*time.Hour).Unix())),
+	}
+	lists, err := processAll(now, &conf.Config{Plans: []conf.Plan{{Name: "a", Paths: []string{"playground/stale"}, Latest: 1, ExpectEvery: time.Hour}}}, zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+
+	withOutput(outputJSON, func(buffer *bytes.Buffer) {
+		status := checkFreshness(buffer, lists)
+		if status != nagiosCritical {
+			t.Fatalf("checkFreshness() returned %d, expected %d", status, nagiosCritical)
+		}
+
+		expected := []record{
+			{Action: actionFreshness, Status: "CRITICAL", Message: "1 critical"},
+			{Action: actionFreshness, Dataset: "playground/stale", Status: "CRITICAL", Message: "newest snapshot playground/stale@snap0 is 13h0m0s old, expected every 1h0m0s"},
+		}
+		if !reflect.DeepEqual(records, expected) || buffer.Len() != 0 {
+			t.Fatalf("checkFreshness() emitted %+v and printed '%s'", records, buffer.String())
+		}
+	})
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -17,6 +19,8 @@ var (
 	verbose     = false
 	dryrun      = false
 	showVersion = false
+	batchSize   = 100
+	failFast    = false
 	// This can be set to a specific time for testing.
 	now = time.Now()
 	// tasks can be added to this for testing.
@@ -37,6 +41,8 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&dryrun, "dryrun", "n", false, "Do nothing destructive, only print")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Be more verbose")
 	rootCmd.PersistentFlags().BoolVarP(&showVersion, "version", "V", false, "Show version and exit")
+	rootCmd.PersistentFlags().IntVar(&batchSize, "batch-size", 100, "Destroy up to this many snapshots per zfs command")
+	rootCmd.PersistentFlags().BoolVar(&failFast, "fail-fast", false, "Stop at the first dataset failing instead of continuing with the rest")
 	rootCmd.TraverseChildren = true
 	zfsExecutor = zfs.NewExecutor()
 }
@@ -50,43 +56,332 @@ func readConfig(r *os.File) (*conf.Config, error) {
 	return config, nil
 }
 
-func processAll(now time.Time, conf *conf.Config, zfsExecutor zfs.Executor) ([]zfs.SnapshotList, error) {
-	lists := []zfs.SnapshotList{}
+// loadConfig reads the configuration at path. It should be used when no lock
+// is required.
+func loadConfig(path string) (*conf.Config, error) {
+	configFile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %s", path, err.Error())
+	}
+	defer configFile.Close()
+	return readConfig(configFile)
+}
+
+// datasetList is the result of applying a plan to a single dataset.
+type datasetList struct {
+	plan      conf.Plan
+	dataset   string
+	snapshots zfs.SnapshotList
+	// unmanaged is the snapshots not matched by the plan. These must
+	// never be touched.
+	unmanaged zfs.SnapshotList
+	// anchor is the time periods was measured from.
+	anchor time.Time
+	// uncommon is the replication peers without a snapshot in common
+	// with the dataset. Nothing will be destroyed if there is any.
+	uncommon []string
+	// merged is the names of all plans merged into this list, if more
+	// than one plan targets the dataset.
+	merged []string
+	// err is set if the plan failed for this dataset. The list will have
+	// no snapshots, and nothing will be destroyed.
+	err error
+}
+
+// anchorName returns the name of the anchor used for l.
+func (l datasetList) anchorName() string {
+	if l.plan.Anchor == "" {
+		return conf.AnchorNow
+	}
+	return l.plan.Anchor
+}
+
+// discover lists the snapshots of all datasets in datasets using a single
+// zfs call per root, as returned by zfs.Roots. Datasets without snapshots are
+// missing from the lists, so datasets in named are checked to exist. Roots
+// and datasets not found are reported and skipped. Permission denied is returned, since every other
+// dataset would fail too.
+func discover(zfsExecutor zfs.Executor, datasets []string, named []string) (map[string]zfs.SnapshotList, error) {
+	snapshots := make(map[string]zfs.SnapshotList)
+	// Roots already reported as failed.
+	failed := []string{}
+	for _, root := range zfs.Roots(datasets) {
+		list, err := zfsExecutor.ListSnapshots(root)
+		if err == nil {
+			var lists map[string]zfs.SnapshotList
+			lists, err = list.Partition()
+			for dataset, list := range lists {
+				snapshots[dataset] = list
+			}
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, zfs.ErrPermissionDenied):
+			return nil, err
+		case errors.Is(err, zfs.ErrDatasetNotFound):
+			reportError(root, fmt.Errorf("dataset %s does not exist, skipping", root))
+			failed = append(failed, root)
+		default:
+			// Write and Continue
+			reportError(root, err)
+			failed = append(failed, root)
+		}
+	}
+	checked := make(map[string]bool)
+	for _, dataset := range named {
+		if _, found := snapshots[dataset]; found || checked[dataset] || underAny(failed, dataset) {
+			continue
+		}
+		checked[dataset] = true
+		_, err := zfsExecutor.HasSnapshot(dataset)
+		switch {
+		case err == nil:
+		case errors.Is(err, zfs.ErrPermissionDenied):
+			return nil, err
+		case errors.Is(err, zfs.ErrDatasetNotFound):
+			reportError(dataset, fmt.Errorf("dataset %s does not exist, skipping", dataset))
+		default:
+			reportError(dataset, err)
+		}
+	}
+	return snapshots, nil
+}
+
+// underAny returns true if dataset is one of roots or a descendant.
+func underAny(roots []string, dataset string) bool {
+	for _, root := range roots {
+		if zfs.Contains(root, dataset) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePaths resolves the paths of every plan in conf to dataset names. The
+// list of filesystems is only requested from zfs if a plan uses patterns.
+func resolvePaths(zfsExecutor zfs.Executor, conf *conf.Config) ([][]string, error) {
+	var filesystems []string
 	for _, plan := range conf.Plans {
-		for _, dataset := range plan.Paths {
-			list := zfs.SnapshotList{}
-			list, err := list.NewSnapshotListFromDataset(zfsExecutor, dataset)
+		if plan.HasPatterns() {
+			output, err := zfsExecutor.GetFilesystems()
 			if err != nil {
-				// Write and Continue when dataset is not found
-				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
-				continue
+				return nil, err
 			}
-			list.KeepNamed(plan.Protect)
-			list.KeepLatest(plan.Latest)
-			err = list.KeepHolds(zfsExecutor)
+			filesystems = strings.Fields(string(output))
+			break
+		}
+	}
+	resolved := make([][]string, len(conf.Plans))
+	for i, plan := range conf.Plans {
+		resolved[i] = plan.Datasets(filesystems)
+	}
+	return resolved, nil
+}
+
+// processAll applies every plan in config to its datasets, and prunes
+// snapshots further for plans with space targets.
+func processAll(now time.Time, config *conf.Config, zfsExecutor zfs.Executor) ([]datasetList, error) {
+	return processSelected(now, config, zfsExecutor, nil)
+}
+
+// processSelected is like processAll, but only applies the plans named in
+// selected. If selected is nil, every plan is applied.
+func processSelected(now time.Time, config *conf.Config, zfsExecutor zfs.Executor, selected map[string]bool) ([]datasetList, error) {
+	lists, err := evaluateAll(now, config, zfsExecutor, selected)
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range lists {
+		if list.hasSpaceTarget() {
+			pruneForSpace(zfsExecutor, lists)
+			break
+		}
+	}
+	return lists, nil
+}
+
+// evaluateAll applies the plans in config named in selected to their
+// datasets, without considering space targets. If selected is nil, every
+// plan is applied.
+func evaluateAll(now time.Time, config *conf.Config, zfsExecutor zfs.Executor, selected map[string]bool) ([]datasetList, error) {
+	all, allResolved, conflicts, err := resolvePlans(zfsExecutor, config, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, conflict := range conflicts {
+		reportError("", conflict)
+	}
+	plans := []conf.Plan{}
+	resolved := [][]string{}
+	for i, plan := range all {
+		if selected == nil || selected[plan.Name] {
+			plans = append(plans, plan)
+			resolved = append(resolved, allResolved[i])
+		}
+	}
+	datasets := []string{}
+	named := []string{}
+	for p, paths := range resolved {
+		datasets = append(datasets, paths...)
+		named = append(named, plans[p].NamedDatasets()...)
+	}
+	snapshots, err := discover(zfsExecutor, datasets, named)
+	if err != nil {
+		return nil, err
+	}
+	lists := []datasetList{}
+	for p, plan := range plans {
+		// Snapshots of replication peers, indexed like plan.Replicas.
+		peers := make([]peerSnapshots, len(plan.Replicas))
+		var failure error
+		for i, replica := range plan.Replicas {
+			peer, err := replicaSnapshots(zfsExecutor, replica)
 			if err != nil {
-				return nil, err
+				// We cannot know what is safe to destroy.
+				failure = fmt.Errorf("plan %s: %s", plan.Name, err.Error())
+				break
+			}
+			peers[i] = peer
+		}
+		if failure != nil && failFast {
+			return nil, failure
+		}
+		if failure != nil {
+			// Only the datasets of this plan are affected.
+			reportError("", failure)
+			for _, dataset := range resolved[p] {
+				lists = append(lists, datasetList{
+					plan:    plan,
+					dataset: dataset,
+					anchor:  now,
+					err:     failure,
+				})
+			}
+			continue
+		}
+		for _, dataset := range resolved[p] {
+			// Plans must not share keep state, so every plan gets a
+			// private copy.
+			list, unmanaged := snapshots[dataset].Copy().Split(func(snapshot *zfs.Snapshot) bool {
+				return plan.Manages(snapshot.SnapshotName())
+			})
+			list.KeepNamed(plan.Protect)
+			for _, pattern := range plan.ProtectPatterns {
+				list.KeepMatching(pattern)
 			}
-			for _, period := range plan.Periods {
-				start := now.Add(-period.Age)
-				list.Sieve(start, period.Frequency)
+			// Periods are measured from the anchor.
+			anchor := now
+			if plan.Anchor == conf.AnchorNewest && len(list) > 0 {
+				anchor = list.Latest().Creation
 			}
-			lists = append(lists, list)
+			location := plan.Location
+			if location == nil {
+				location = time.Local
+			}
+			// Each class is sieved independently. Snapshots not
+			// matching any class are sieved by the periods of the
+			// plan itself. The latest of the plan applies to every
+			// snapshot, so the newest snapshot is always kept.
+			rest := list
+			for _, class := range plan.Classes {
+				var members zfs.SnapshotList
+				members, rest = rest.Split(func(snapshot *zfs.Snapshot) bool {
+					return class.Match.Match(snapshot.SnapshotName())
+				})
+				prefix := fmt.Sprintf("class %s: ", class.Name)
+				if class.Latest > 0 {
+					members.KeepLatestRule(class.Latest, fmt.Sprintf("%skeep latest %d", prefix, class.Latest))
+				}
+				keepPeriods(anchor, members, class.Periods, class.Calendar, location, prefix)
+			}
+			list.KeepLatest(plan.Latest)
+			list.KeepHolds()
+			list.KeepClones(plan.CloneAllowed)
+			keepPeriods(anchor, rest, plan.Periods, plan.Calendar, location, "")
+			var uncommon []string
+			for i, peer := range peers {
+				names := peer.forDataset(dataset, len(resolved[p]) == 1)
+				if list.KeepLatestCommon(names, plan.Replicas[i].String()) == nil && len(list) > 0 {
+					uncommon = append(uncommon, plan.Replicas[i].String())
+				}
+			}
+			lists = append(lists, datasetList{
+				plan:      plan,
+				dataset:   dataset,
+				snapshots: list,
+				unmanaged: unmanaged,
+				anchor:    anchor,
+				uncommon:  uncommon,
+			})
 		}
 	}
-	return lists, nil
+	return mergeOverlaps(config, lists, snapshots)
+}
+
+// keepPeriods will keep snapshots in list according to periods and calendar
+// periods measured from anchor. prefix is prepended to the rule recorded for
+// each snapshot kept.
+func keepPeriods(anchor time.Time, list zfs.SnapshotList, periods []conf.Period, calendar []conf.CalendarPeriod, location *time.Location, prefix string) {
+	for _, period := range periods {
+		start := anchor.Add(-period.Age)
+		list.SieveRule(start, period.Frequency, prefix+period.String())
+	}
+	for _, c := range calendar {
+		list.KeepCalendar(anchor, zfs.CalendarUnit(c.Unit), c.Count, location, prefix+c.String())
+	}
 }
 
 func main() {
 	AddPlanCheckCommand(zfsExecutor)
+	AddExplainCommand(zfsExecutor)
+	AddPlanCommand(zfsExecutor)
+	AddApplyCommand(zfsExecutor)
+	AddDaemonCommand(zfsExecutor)
+	AddCheckFreshnessCommand(zfsExecutor)
 	err := rootCmd.Execute()
 	if err != nil {
 		if panicBail {
 			panic(err.Error())
 		}
-		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		if result, ok := err.(*nagiosResult); ok {
+			// The status has already been written.
+			flushOutput()
+			os.Exit(result.status)
+		}
+		reportError("", err)
+		flushOutput()
+		if _, ok := err.(*partialFailure); ok {
+			os.Exit(exitPartialFailure)
+		}
 		os.Exit(1)
 	}
+	flushOutput()
+}
+
+// lockConfig will open and parse the configuration at path and acquire an
+// exclusive lock on it. The returned function will release the lock.
+func lockConfig(path string) (*conf.Config, func(), error) {
+	confFile, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %s", path, err.Error())
+	}
+	conf, err := readConfig(confFile)
+	if err != nil {
+		confFile.Close()
+		return nil, nil, err
+	}
+	fd := int(confFile.Fd())
+	err = syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		confFile.Close()
+		return nil, nil, fmt.Errorf("could not acquire lock on '%s'", confFile.Name())
+	}
+	unlock := func() {
+		// We can ignore errors here, we're exiting anyway.
+		_ = syscall.Flock(fd, syscall.LOCK_UN)
+		_ = confFile.Close()
+	}
+	return conf, unlock, nil
 }
 
 func clean(cmd *cobra.Command, args []string) error {
@@ -97,57 +392,257 @@ func clean(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("%s /path/to/config.conf", cmd.Name())
 	}
 	configPath := args[0]
-	confFile, err := os.Open(configPath)
-	if err != nil {
-		return fmt.Errorf("failed to open %s: %s", args[0], err.Error())
-	}
-	defer confFile.Close()
-	conf, err := readConfig(confFile)
+	conf, unlock, err := lockConfig(configPath)
 	if err != nil {
 		return err
 	}
-	fd := int(confFile.Fd())
-	err = syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
-	if err != nil {
-		return fmt.Errorf("could not acquire lock on '%s'", confFile.Name())
-	}
 	// make sure to unlock :)
-	defer func() {
-		// We can ignore errors here, we're exiting anyway.
-		_ = syscall.Flock(fd, syscall.LOCK_UN)
-	}()
+	defer unlock()
 	if err := zfsExecutor.HasZFSCommand(); err != nil {
 		return err
 	}
-	lists, err := processAll(now, conf, zfsExecutor)
+	err = cleanPlans(zfsExecutor, configPath, conf)
+	if err != nil {
+		return err
+	}
+	mainWaitGroup.Wait()
+	return nil
+}
+
+// cleanPlans will destroy snapshots according to all plans in conf.
+func cleanPlans(zfsExecutor zfs.Executor, configPath string, conf *conf.Config) error {
+	return cleanSelected(zfsExecutor, configPath, conf, nil)
+}
+
+// cleanSelected will destroy snapshots according to the plans in conf named
+// in selected. If selected is nil, every plan is used.
+func cleanSelected(zfsExecutor zfs.Executor, configPath string, conf *conf.Config, selected map[string]bool) error {
+	start := time.Now()
+	lists, err := processSelected(now, conf, zfsExecutor, selected)
 	if err != nil {
 		return err
 	}
-	// Start by generating a list of stuff to do.
-	todos := []todo{}
+	// Start by generating a list of stuff to do. Todos are kept per
+	// dataset, a failure will only stop the dataset failing.
+	preamble := []todo{}
 	// Print plan when verbose.
 	if verbose {
-		todos = append(todos, newComment("Config: '%s'", configPath))
+		preamble = append(preamble, newComment("Config: '%s'", configPath))
 		for _, plan := range conf.Plans {
-			todos = append(todos, newComment("Plan: %+v", plan))
+			if selected == nil || selected[plan.Name] {
+				preamble = append(preamble, newComment("Plan: %+v", plan))
+			}
 		}
 	}
-	for _, list := range lists {
-		for _, snapshot := range list {
+	todos := make([][]todo, len(lists))
+	// Batches for each list, used for metrics.
+	batches := make([][]*destroyBatch, len(lists))
+	// failures is the reason each dataset failed or was refused.
+	failures := make([]error, len(lists))
+	refused := 0
+	retry := newRetryPolicy(conf)
+	for i, list := range lists {
+		if list.err != nil {
+			// Already reported by processAll.
+			failures[i] = list.err
+			continue
+		}
+		if verbose {
+			todos[i] = append(todos[i], newComment("Dataset: '%s' anchor %s (%s)", list.dataset, list.anchorName(), list.anchor.Format(time.RFC3339)))
+		}
+		for _, snapshot := range list.unmanaged {
+			todos[i] = append(todos[i], newUnmanaged(list, snapshot))
+		}
+		doomed := zfs.SnapshotList{}
+		for _, snapshot := range list.snapshots {
+			todos[i] = append(todos[i], newDecision(list, snapshot))
 			if !snapshot.Keep {
-				todos = append(todos, newDestroy(zfsExecutor, snapshot))
-			} else {
-				todos = append(todos, newComment("Keep %s (Age %s)", snapshot.Name, now.Sub(snapshot.Creation)))
+				doomed = append(doomed, snapshot)
+			}
+		}
+		if err := checkDataset(conf, list); err != nil {
+			reportError(list.dataset, err)
+			failures[i] = err
+			refused++
+			continue
+		}
+		for _, snapshot := range list.snapshots {
+			if keptForClones(snapshot) {
+				todos[i] = append(todos[i], newNotice("Keeping %s, it has dependent clones %s", snapshot.Name, strings.Join(snapshot.Clones, ", ")))
 			}
 		}
+		// Snapshots with clones left in doomed have only allowed clones.
+		// These must be destroyed one at a time along with the clones.
+		cloned, plain := doomed.Split(func(snapshot *zfs.Snapshot) bool {
+			return len(snapshot.Clones) > 0
+		})
+		for _, batch := range plain.Batches(batchSize) {
+			d := newDestroyBatch(zfsExecutor, list.planName(), batch)
+			d.retry = retry
+			batches[i] = append(batches[i], d)
+			todos[i] = append(todos[i], d)
+		}
+		for _, snapshot := range cloned {
+			d := newDestroyBatch(zfsExecutor, list.planName(), zfs.SnapshotList{snapshot})
+			d.retry = retry
+			d.recursive = true
+			batches[i] = append(batches[i], d)
+			todos[i] = append(todos[i], d)
+		}
+	}
+	// Estimates must be made before anything is destroyed.
+	estimates := make([]reclaimEstimate, len(lists))
+	if dryrun || showSummary {
+		for i := range lists {
+			estimates[i] = estimateReclaim(batches[i])
+		}
 	}
 	// And then do it! :-)
-	for _, todo := range todos {
-		err := todo.Do()
-		if err != nil {
-			return err
+	for _, todo := range preamble {
+		_ = todo.Do()
+	}
+	for i := range lists {
+		for _, todo := range todos[i] {
+			err = todo.Do()
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			continue
+		}
+		// Without permissions every other dataset will fail too.
+		if failFast || errors.Is(err, zfs.ErrPermissionDenied) {
+			break
 		}
+		reportError(lists[i].dataset, err)
+		failures[i] = err
+		err = nil
+	}
+	destroyed := make([]int, len(lists))
+	failed := make([]int, len(lists))
+	for i := range lists {
+		abandoned := 0
+		for _, batch := range batches[i] {
+			destroyed[i] += batch.destroyed
+			failed[i] += batch.failed
+			abandoned += batch.abandoned()
+		}
+		if failures[i] == nil && abandoned > 0 {
+			failures[i] = fmt.Errorf("gave up on %d snapshot(s) after retrying", abandoned)
+		}
+	}
+	reportRetries(batches)
+	if dryrun || showSummary {
+		reportReclaim(lists, batches, estimates)
+	}
+	if err == nil && failFast && refused > 0 {
+		err = fmt.Errorf("refused to clean %d dataset(s), nothing destroyed for those", refused)
+	}
+	if err == nil && !failFast {
+		err = summarize(lists, destroyed, failed, failures)
+	}
+	plans := []string{}
+	for _, plan := range conf.Plans {
+		if selected == nil || selected[plan.Name] {
+			plans = append(plans, plan.Name)
+		}
+	}
+	metrics.record(plans, lists, destroyed, failed, start, time.Since(start), err == nil)
+	if metricsTextfile != "" {
+		if err := metrics.writeTextfile(metricsTextfile, plans, time.Now()); err != nil {
+			reportError("", fmt.Errorf("failed to write metrics to %s: %s", metricsTextfile, err.Error()))
+		}
+	}
+	return err
+}
+
+// checkDataset returns an error if no snapshots should be destroyed in the
+// dataset of list, because the dataset is stale, has no snapshot in common
+// with a replication peer, or the destroy limits would be exceeded.
+func checkDataset(config *conf.Config, list datasetList) error {
+	if err := checkStale(list); err != nil {
+		return err
+	}
+	if err := checkReplicas(list); err != nil {
+		return err
+	}
+	if err := checkClones(list); err != nil {
+		return err
+	}
+	return checkDestroyLimits(config, list)
+}
+
+// checkReplicas returns an error if list has no snapshot in common with a
+// replication peer. Destroying more could remove the snapshot the peer needs
+// for an incremental send, if the peer is merely lagging behind.
+func checkReplicas(list datasetList) error {
+	if len(list.uncommon) == 0 {
+		return nil
+	}
+	return fmt.Errorf("refusing to destroy snapshots in %s: no snapshot in common with %s", list.dataset, strings.Join(list.uncommon, ", "))
+}
+
+// keptForClones returns true if snapshot is kept only because of its
+// clones.
+func keptForClones(snapshot *zfs.Snapshot) bool {
+	if !snapshot.Keep {
+		return false
+	}
+	for _, reason := range snapshot.Reasons {
+		if reason.Kind != zfs.ReasonClones {
+			return false
+		}
+	}
+	return true
+}
+
+// checkClones returns an error if the plan of list wants errors for
+// snapshots kept only because of their clones, and list has such a
+// snapshot.
+func checkClones(list datasetList) error {
+	if list.plan.ClonePolicy() != conf.ClonesError {
+		return nil
+	}
+	for _, snapshot := range list.snapshots {
+		if keptForClones(snapshot) {
+			return fmt.Errorf("refusing to destroy snapshots in %s: %s has dependent clones %s", list.dataset, snapshot.Name, strings.Join(snapshot.Clones, ", "))
+		}
+	}
+	return nil
+}
+
+// checkStale returns an error if the newest snapshot in list is older than
+// allowed by the plan. A stale dataset usually means that snapshotting or
+// replication is broken, and we should not make it worse by destroying
+// more.
+func checkStale(list datasetList) error {
+	latest := list.snapshots.Latest()
+	if list.plan.PauseIfStale <= 0 || latest == nil {
+		return nil
+	}
+	age := now.Sub(latest.Creation)
+	if age > list.plan.PauseIfStale {
+		return fmt.Errorf("refusing to destroy snapshots in %s: newest snapshot %s is %s old, exceeding pause-if-stale %s. Is snapshotting or replication broken?", list.dataset, latest.Name, age, list.plan.PauseIfStale)
+	}
+	return nil
+}
+
+// checkDestroyLimits returns an error if destroying all snapshots not kept in
+// list would exceed the destroy limits for the plan.
+func checkDestroyLimits(config *conf.Config, list datasetList) error {
+	maxDestroy, maxDestroyPercent := config.DestroyLimits(&list.plan)
+	doomed := 0
+	for _, snapshot := range list.snapshots {
+		if !snapshot.Keep {
+			doomed++
+		}
+	}
+	if maxDestroy > 0 && doomed > maxDestroy {
+		return fmt.Errorf("refusing to destroy %d of %d snapshots in %s: exceeds max-destroy %d", doomed, len(list.snapshots), list.dataset, maxDestroy)
+	}
+	if maxDestroyPercent > 0 && doomed*100 > maxDestroyPercent*len(list.snapshots) {
+		return fmt.Errorf("refusing to destroy %d of %d snapshots in %s: exceeds max-destroy-percent %d", doomed, len(list.snapshots), list.dataset, maxDestroyPercent)
 	}
-	mainWaitGroup.Wait()
 	return nil
 }
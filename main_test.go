@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"github.com/cego/zfs-cleaner/zfs"
 	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -112,32 +116,124 @@ type testExecutor struct {
 	zfsCommandName        string
 	getSnapshotListResult []byte
 	getSnapshotListError  error
+	guids                 map[string]string
+	destroyed             []string
+	batches               []string
+	destroySnapshotsError error
+	filesystems           []byte
+	properties            []byte
+	usage                 map[string]zfs.Usage
+	pools                 map[string]zfs.PoolSpace
+	reclaim               map[string]uint64
+	// reclaimShared is space shared by the snapshots in the key, like
+	// "dataset@a,b", only reclaimed when estimating them together.
+	reclaimShared         map[string]uint64
+	destroySnapshotErrors map[string]error
+	// busy is how many times destroying a snapshot fails as busy before
+	// succeeding.
+	busy map[string]int
+	// recursive is the snapshots destroyed along with their clones.
+	recursive []string
+	// hasSnapshotErrors is returned when checking if a dataset exists.
+	hasSnapshotErrors map[string]error
 }
 
 func (t *testExecutor) HasZFSCommand() error {
 	return nil
 }
 
-func (t *testExecutor) GetSnapshotList(dataset string) ([]byte, error) {
-	return t.getSnapshotListResult, t.getSnapshotListError
+func (t *testExecutor) ListSnapshots(root string) (zfs.SnapshotList, error) {
+	if t.getSnapshotListError != nil {
+		return nil, t.getSnapshotListError
+	}
+	return zfs.NewSnapshotListFromOutput(t.getSnapshotListResult)
 }
 
 func (t *testExecutor) GetFilesystems() ([]byte, error) {
-	panic("implement me")
+	if t.filesystems == nil {
+		panic("implement me")
+	}
+	return t.filesystems, nil
 }
 
-func (t *testExecutor) HasSnapshot(dataset string) (bool, error) {
-	panic("implement me")
+func (t *testExecutor) GetUserProperties(names []string) ([]zfs.Property, error) {
+	if t.properties == nil {
+		panic("implement me")
+	}
+	return zfs.NewPropertiesFromOutput(t.properties)
+}
+
+func (t *testExecutor) GetUsage(datasets []string) (map[string]zfs.Usage, error) {
+	if t.usage == nil {
+		panic("implement me")
+	}
+	return t.usage, nil
+}
+
+func (t *testExecutor) GetPoolSpace(pool string) (zfs.PoolSpace, error) {
+	space, found := t.pools[pool]
+	if !found {
+		return zfs.PoolSpace{}, fmt.Errorf("pool does not exist: %s", pool)
+	}
+	return space, nil
 }
 
-func (t *testExecutor) HasHolds(dataset string) (bool, error) {
+func (t *testExecutor) HasSnapshot(dataset string) (bool, error) {
+	if err := t.hasSnapshotErrors[dataset]; err != nil {
+		return false, err
+	}
 	return false, nil
 }
 
+func (t *testExecutor) GetGUID(snapshot string) (string, error) {
+	guid, found := t.guids[snapshot]
+	if !found {
+		return "", fmt.Errorf("dataset does not exist: %s", snapshot)
+	}
+	return guid, nil
+}
+
 func (t *testExecutor) DestroySnapshot(dataset string) ([]byte, error) {
+	if err := t.destroySnapshotErrors[dataset]; err != nil {
+		return nil, err
+	}
+	if t.busy[dataset] > 0 {
+		t.busy[dataset]--
+		return nil, &zfs.CommandError{Op: "failed to destroy snapshot", Err: zfs.ErrBusy}
+	}
+	t.destroyed = append(t.destroyed, dataset)
+	return nil, nil
+}
+
+func (t *testExecutor) DestroySnapshots(dataset string, names []string) ([]byte, error) {
+	t.batches = append(t.batches, dataset+"@"+strings.Join(names, ","))
+	if t.destroySnapshotsError != nil {
+		return nil, t.destroySnapshotsError
+	}
+	for _, name := range names {
+		t.destroyed = append(t.destroyed, dataset+"@"+name)
+	}
+	return nil, nil
+}
+
+func (t *testExecutor) DestroySnapshotRecursive(snapshot string) ([]byte, error) {
+	t.recursive = append(t.recursive, snapshot)
 	return nil, nil
 }
 
+func (t *testExecutor) EstimateReclaim(dataset string, names []string) (uint64, error) {
+	var reclaim uint64
+	for _, name := range names {
+		used, found := t.reclaim[dataset+"@"+name]
+		if !found {
+			return 0, fmt.Errorf("could not find snapshot: %s@%s", dataset, name)
+		}
+		reclaim += used
+	}
+	reclaim += t.reclaimShared[dataset+"@"+strings.Join(names, ",")]
+	return reclaim, nil
+}
+
 func TestProcessAll(t *testing.T) {
 	zfsTestExecutor := testExecutor{
 		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570
@@ -278,3 +374,627 @@ func TestConcurrency(t *testing.T) {
 		t.Fatalf("clean() returned an error: %s", cleanErr.Error())
 	}
 }
+
+func TestExplain(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	zfsTestExecutor := testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570
+playground/fs1@snap2	1492989572
+playground/fs1@snap3	1492989587
+`),
+	}
+
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{
+				Name:    "buh",
+				Paths:   []string{"playground/fs1"},
+				Latest:  1,
+				Protect: []string{"snap1"},
+			},
+		},
+	}
+
+	lists, err := processAll(now, config, &zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+
+	buffer := &bytes.Buffer{}
+	err = explain(buffer, lists, "")
+	if err != nil {
+		t.Fatalf("explain() returned error: %s", err.Error())
+	}
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("explain() returned %d lines, expected 4:\n%s", len(lines), buffer.String())
+	}
+
+	expected := []struct {
+		name   string
+		action string
+		reason string
+	}{
+		{"snap1", "keep", "protect: snap1"},
+		{"snap2", "destroy", ""},
+		{"snap3", "keep", "latest: latest 1"},
+	}
+
+	for i, e := range expected {
+		line := lines[i+1]
+		if !strings.Contains(line, e.name) || !strings.Contains(line, e.action) || !strings.Contains(line, e.reason) {
+			t.Errorf("%d explain() returned unexpected line '%s'", i, line)
+		}
+	}
+
+	err = explain(buffer, lists, "playground/nonexisting")
+	if err == nil {
+		t.Errorf("explain() did not err on unplanned dataset")
+	}
+}
+
+func TestDestroyBatch(t *testing.T) {
+	snapshots := zfs.SnapshotList{
+		&zfs.Snapshot{Name: "playground/fs1@snap1"},
+		&zfs.Snapshot{Name: "playground/fs1@snap2"},
+	}
+
+	zfsTestExecutor := &testExecutor{}
+	err := newDestroyBatch(zfsTestExecutor, "buh", snapshots).Do()
+	if err != nil {
+		t.Fatalf("Do() returned error: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(zfsTestExecutor.batches, []string{"playground/fs1@snap1,snap2"}) {
+		t.Fatalf("Do() ran wrong batches: %v", zfsTestExecutor.batches)
+	}
+
+	// A failing batch should fall back to destroying one at a time.
+	zfsTestExecutor = &testExecutor{
+		destroySnapshotsError: fmt.Errorf("failed"),
+	}
+	err = newDestroyBatch(zfsTestExecutor, "buh", snapshots).Do()
+	if err != nil {
+		t.Fatalf("Do() returned error: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(zfsTestExecutor.destroyed, []string{"playground/fs1@snap1", "playground/fs1@snap2"}) {
+		t.Fatalf("Do() did not fall back to single destroys: %v", zfsTestExecutor.destroyed)
+	}
+}
+
+func TestDestroyBatchTypedErrors(t *testing.T) {
+	snapshots := zfs.SnapshotList{
+		&zfs.Snapshot{Name: "playground/fs1@gone"},
+		&zfs.Snapshot{Name: "playground/fs1@cloned"},
+		&zfs.Snapshot{Name: "playground/fs1@snap3"},
+		&zfs.Snapshot{Name: "playground/fs1@denied"},
+		&zfs.Snapshot{Name: "playground/fs1@snap5"},
+	}
+
+	zfsTestExecutor := &testExecutor{
+		destroySnapshotsError: &zfs.CommandError{Op: "failed to destroy snapshots", Err: zfs.ErrHasClones},
+		destroySnapshotErrors: map[string]error{
+			"playground/fs1@gone":   &zfs.CommandError{Op: "failed to destroy snapshot", Err: zfs.ErrDatasetNotFound},
+			"playground/fs1@cloned": &zfs.CommandError{Op: "failed to destroy snapshot", Err: zfs.ErrHasClones},
+			"playground/fs1@denied": &zfs.CommandError{Op: "failed to destroy snapshot", Err: zfs.ErrPermissionDenied},
+		},
+	}
+	batch := newDestroyBatch(zfsTestExecutor, "buh", snapshots)
+	err := batch.Do()
+	if !errors.Is(err, zfs.ErrPermissionDenied) {
+		t.Fatalf("Do() returned %v, expected permission denied", err)
+	}
+
+	// Missing snapshots and snapshots with clones must not stop the
+	// batch, but other errors must.
+	if !reflect.DeepEqual(zfsTestExecutor.destroyed, []string{"playground/fs1@snap3"}) {
+		t.Fatalf("Do() destroyed %v", zfsTestExecutor.destroyed)
+	}
+
+	if batch.destroyed != 1 || batch.failed != 2 {
+		t.Fatalf("Do() counted %d destroyed and %d failed, expected 1 and 2", batch.destroyed, batch.failed)
+	}
+}
+
+func TestCleanPlansPermissionDenied(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570
+playground/fs1@snap2	1492989571
+playground/fs2@snap1	1492989572
+playground/fs2@snap2	1492989573
+`),
+		destroySnapshotErrors: map[string]error{
+			"playground/fs1@snap1": &zfs.CommandError{Op: "failed to destroy snapshot", Err: zfs.ErrPermissionDenied},
+		},
+	}
+	config := &conf.Config{
+		Plans: []conf.Plan{{Name: "buh", Paths: []string{"playground/fs1", "playground/fs2"}, Latest: 1}},
+	}
+
+	err := cleanPlans(zfsTestExecutor, "test.conf", config)
+	if !errors.Is(err, zfs.ErrPermissionDenied) {
+		t.Fatalf("cleanPlans() returned %v, expected permission denied", err)
+	}
+
+	if len(zfsTestExecutor.destroyed) != 0 {
+		t.Fatalf("cleanPlans() continued after permission denied: %v", zfsTestExecutor.destroyed)
+	}
+}
+
+func TestProcessAllDiscovery(t *testing.T) {
+	zfsTestExecutor := testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570	1	1001
+playground/fs2@snap1	1492989571	0	2001
+playground/fs1@snap2	1492989572	0	1002
+playground/fs1@snap3	1492989573	0	1003
+playground/fs2@snap2	1492989574	0	2002
+`),
+	}
+
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{
+				Name:   "buh",
+				Paths:  []string{"playground/fs1", "playground/fs2"},
+				Latest: 1,
+			},
+		},
+	}
+
+	lists, err := processAll(time.Unix(1492993419, 0), config, &zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+
+	if len(lists) != 2 {
+		t.Fatalf("processAll() returned wrong number of lists, got %d", len(lists))
+	}
+
+	expected := []string{
+		"[ playground/fs1@snap1:1492989570:true playground/fs1@snap2:1492989572:false playground/fs1@snap3:1492989573:true ]",
+		"[ playground/fs2@snap1:1492989571:false playground/fs2@snap2:1492989574:true ]",
+	}
+
+	for i, list := range lists {
+		if list.snapshots.String() != expected[i] {
+			t.Errorf("%d processAll() returned %s, expected %s", i, list.snapshots.String(), expected[i])
+		}
+	}
+}
+
+func TestDiscoverMissing(t *testing.T) {
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570
+`),
+		hasSnapshotErrors: map[string]error{
+			"playground/typo": &zfs.CommandError{Op: "failed to get snapshot list", Err: zfs.ErrDatasetNotFound},
+		},
+	}
+
+	withOutput(outputJSON, func(buffer *bytes.Buffer) {
+		snapshots, err := discover(zfsTestExecutor, []string{"playground/fs1", "playground/empty", "playground/typo"}, []string{"playground/fs1", "playground/empty", "playground/typo"})
+		if err != nil {
+			t.Fatalf("discover() returned error: %s", err.Error())
+		}
+
+		if len(snapshots["playground/fs1"]) != 1 || len(snapshots["playground/typo"]) != 0 {
+			t.Fatalf("discover() returned %v", snapshots)
+		}
+
+		if len(records) != 1 || records[0].Action != actionError || records[0].Dataset != "playground/typo" {
+			t.Fatalf("discover() did not report the missing dataset only, got %+v", records)
+		}
+	})
+}
+
+func TestDiscoverTypedErrors(t *testing.T) {
+	cases := []struct {
+		err   error
+		abort bool
+	}{
+		{&zfs.CommandError{Op: "failed to get snapshot list", Err: zfs.ErrDatasetNotFound}, false},
+		{&zfs.CommandError{Op: "failed to get snapshot list", Err: zfs.ErrPermissionDenied}, true},
+		{errors.New("something else"), false},
+	}
+
+	for i, c := range cases {
+		withOutput(outputJSON, func(buffer *bytes.Buffer) {
+			zfsTestExecutor := &testExecutor{getSnapshotListError: c.err}
+			datasets := []string{"playground/a/fs1", "playground/a/fs2"}
+			_, err := discover(zfsTestExecutor, datasets, datasets)
+			if (err != nil) != c.abort {
+				t.Fatalf("%d discover() returned %v", i, err)
+			}
+
+			// Both datasets are listed from their common ancestor.
+			if !c.abort && (len(records) != 1 || records[0].Dataset != "playground/a") {
+				t.Fatalf("%d discover() did not report the root once, got %+v", i, records)
+			}
+		})
+	}
+}
+
+func TestProcessAllPatterns(t *testing.T) {
+	zfsTestExecutor := testExecutor{
+		filesystems: []byte("playground\nplayground/fs1\nplayground/fs1/child\nplayground/fs2\nplayground/tmp\nplayground/tmp/child\n"),
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570	0	1001
+playground/fs1/child@snap1	1492989571	0	1101
+playground/fs2@snap1	1492989572	0	2001
+playground/tmp@snap1	1492989573	0	3001
+`),
+	}
+
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{
+				Name:           "buh",
+				RecursivePaths: []string{"playground"},
+				Excludes:       []string{"playground/tmp"},
+				Latest:         1,
+			},
+		},
+	}
+
+	lists, err := processAll(time.Unix(1492993419, 0), config, &zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+
+	expected := []string{"playground", "playground/fs1", "playground/fs1/child", "playground/fs2"}
+	if len(lists) != len(expected) {
+		t.Fatalf("processAll() returned wrong number of lists, got %d", len(lists))
+	}
+
+	for i, list := range lists {
+		if list.dataset != expected[i] {
+			t.Errorf("%d processAll() returned %s, expected %s", i, list.dataset, expected[i])
+		}
+	}
+}
+
+func TestPlanCheckPatterns(t *testing.T) {
+	zfsTestExecutor := testExecutor{
+		filesystems: []byte("playground\nplayground/fs1\nplayground/fs2\nplayground/tmp\n"),
+	}
+
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{
+				Name:     "buh",
+				Paths:    []string{"playground/*"},
+				Excludes: []string{"playground/tmp"},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	stdout = &buf
+	defer func() { stdout = os.Stdout }()
+
+	err := planCheck(&zfsTestExecutor, config, false)
+	if err != nil {
+		t.Fatalf("planCheck() returned error: %s", err.Error())
+	}
+
+	expected := "No plan found for path: 'playground'\nNo plan found for path: 'playground/tmp'\n"
+	if buf.String() != expected {
+		t.Fatalf("planCheck() printed '%s', expected '%s'", buf.String(), expected)
+	}
+}
+
+func TestProcessAllCalendar(t *testing.T) {
+	zfsTestExecutor := testExecutor{
+		// 2020-03-01 00:00, 2020-03-01 12:00, 2020-03-02 00:00 and
+		// 2020-03-02 12:00 UTC.
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1583020800
+playground/fs1@snap2	1583064000
+playground/fs1@snap3	1583107200
+playground/fs1@snap4	1583150400
+`),
+	}
+
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{
+				Name:     "buh",
+				Paths:    []string{"playground/fs1"},
+				Latest:   0,
+				Calendar: []conf.CalendarPeriod{{Unit: "daily", Count: 2}},
+				Location: time.UTC,
+			},
+		},
+	}
+
+	lists, err := processAll(time.Unix(1583157600, 0), config, &zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+
+	expected := "[ playground/fs1@snap1:1583020800:true playground/fs1@snap2:1583064000:false playground/fs1@snap3:1583107200:true playground/fs1@snap4:1583150400:false ]"
+	if lists[0].snapshots.String() != expected {
+		t.Fatalf("processAll() returned %s, expected %s", lists[0].snapshots.String(), expected)
+	}
+}
+
+func TestCleanPlansDestroyLimits(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570
+playground/fs1@snap2	1492989571
+playground/fs1@snap3	1492989572
+playground/fs2@snap1	1492989573
+playground/fs2@snap2	1492989574
+playground/fs2@snap3	1492989575
+playground/fs2@snap4	1492989576
+playground/fs2@snap5	1492989577
+`),
+	}
+
+	config := &conf.Config{
+		MaxDestroy: 3,
+		Plans: []conf.Plan{
+			{
+				Name:   "buh",
+				Paths:  []string{"playground/fs1", "playground/fs2"},
+				Latest: 1,
+			},
+		},
+	}
+
+	err := cleanPlans(zfsTestExecutor, "test.conf", config)
+	if err == nil {
+		t.Fatalf("cleanPlans() did not err when exceeding max-destroy")
+	}
+
+	expected := []string{"playground/fs1@snap1", "playground/fs1@snap2"}
+	if !reflect.DeepEqual(zfsTestExecutor.destroyed, expected) {
+		t.Fatalf("cleanPlans() destroyed %v, expected %v", zfsTestExecutor.destroyed, expected)
+	}
+
+	cases := []struct {
+		maxDestroy        int
+		maxDestroyPercent int
+		refused           bool
+	}{
+		{0, 0, false},
+		{2, 0, false},
+		{1, 0, true},
+		{0, 67, false},
+		{0, 66, true},
+	}
+
+	lists, _ := processAll(now, config, zfsTestExecutor)
+	for i, c := range cases {
+		config := &conf.Config{MaxDestroy: c.maxDestroy, MaxDestroyPercent: c.maxDestroyPercent}
+		err := checkDestroyLimits(config, lists[0])
+		if (err != nil) != c.refused {
+			t.Errorf("%d checkDestroyLimits() returned %v", i, err)
+		}
+	}
+}
+
+func TestCleanPlansMatch(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@auto-1	1492989570
+playground/fs1@manual	1492989571
+playground/fs1@auto-2	1492989572
+playground/fs1@auto-3	1492989573
+`),
+	}
+
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{
+				Name:   "buh",
+				Paths:  []string{"playground/fs1"},
+				Latest: 1,
+				Match:  []conf.Pattern{{Kind: conf.PatternGlob, Expr: "auto-*"}},
+			},
+		},
+	}
+
+	lists, err := processAll(now, config, zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+
+	if lists[0].unmanaged.String() != "[ playground/fs1@manual:1492989571:false ]" {
+		t.Fatalf("processAll() returned unexpected unmanaged snapshots: %s", lists[0].unmanaged.String())
+	}
+
+	err = cleanPlans(zfsTestExecutor, "test.conf", config)
+	if err != nil {
+		t.Fatalf("cleanPlans() returned error: %s", err.Error())
+	}
+
+	expected := []string{"playground/fs1@auto-1,auto-2"}
+	if !reflect.DeepEqual(zfsTestExecutor.batches, expected) {
+		t.Fatalf("cleanPlans() destroyed %v, expected %v", zfsTestExecutor.batches, expected)
+	}
+
+	buffer := &bytes.Buffer{}
+	err = explain(buffer, lists, "")
+	if err != nil {
+		t.Fatalf("explain() returned error: %s", err.Error())
+	}
+
+	if !strings.Contains(buffer.String(), "manual") || !strings.Contains(buffer.String(), "unmanaged") {
+		t.Fatalf("explain() did not report unmanaged snapshot:\n%s", buffer.String())
+	}
+}
+
+func TestProcessAllClasses(t *testing.T) {
+	zfsTestExecutor := testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@daily-1	1492989570
+playground/fs1@hourly-1	1492989571
+playground/fs1@manual-1	1492989572
+playground/fs1@hourly-2	1492989573
+playground/fs1@hourly-3	1492989574
+playground/fs1@daily-2	1492989575
+playground/fs1@hourly-4	1492989576
+playground/fs1@manual-2	1492989577
+`),
+	}
+
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{
+				Name:   "buh",
+				Paths:  []string{"playground/fs1"},
+				Latest: 1,
+				Classes: []conf.Class{
+					{Name: "hourly", Match: conf.Pattern{Kind: conf.PatternGlob, Expr: "hourly-*"}, Latest: 2},
+					{Name: "daily", Match: conf.Pattern{Kind: conf.PatternGlob, Expr: "daily-*"}, Latest: 2},
+				},
+			},
+		},
+	}
+
+	lists, err := processAll(time.Unix(1492993419, 0), config, &zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+
+	expected := map[string]bool{
+		"daily-1":  true,
+		"hourly-1": false,
+		"manual-1": false,
+		"hourly-2": false,
+		"hourly-3": true,
+		"daily-2":  true,
+		"hourly-4": true,
+		"manual-2": true,
+	}
+
+	for _, snapshot := range lists[0].snapshots {
+		if snapshot.Keep != expected[snapshot.SnapshotName()] {
+			t.Errorf("processAll() set Keep to %v for %s", snapshot.Keep, snapshot.Name)
+		}
+	}
+
+	reason := lists[0].snapshots[6].Reasons[0].String()
+	if reason != "latest: class hourly: keep latest 2" {
+		t.Errorf("processAll() recorded unexpected reason: %s", reason)
+	}
+
+	// The newest snapshot is kept by the plan, even when its class keeps
+	// nothing.
+	config.Plans[0].Classes = []conf.Class{
+		{Name: "manual", Match: conf.Pattern{Kind: conf.PatternGlob, Expr: "manual-*"}},
+	}
+	lists, err = processAll(time.Unix(1492993419, 0), config, &zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+
+	newest := lists[0].snapshots.Latest()
+	if !newest.Keep || newest.Reasons[0].String() != "latest: latest 1" {
+		t.Errorf("processAll() did not keep the newest snapshot %s: %v", newest.Name, newest.Reasons)
+	}
+}
+
+func TestProcessAllAnchor(t *testing.T) {
+	// Hourly snapshots ending three weeks before now.
+	now := time.Unix(1492993419, 0)
+	newest := now.Add(-21 * 24 * time.Hour)
+	output := ""
+	for i := 3; i >= 0; i-- {
+		output += fmt.Sprintf("playground/fs1@snap%d\t%d\n", 3-i, newest.Add(-time.Duration(i)*time.Hour).Unix())
+	}
+
+	cases := []struct {
+		anchor   string
+		expected []bool
+		time     time.Time
+	}{
+		{"", []bool{false, false, false, true}, now},
+		{conf.AnchorNow, []bool{false, false, false, true}, now},
+		{conf.AnchorNewest, []bool{true, true, true, true}, newest},
+	}
+
+	for i, c := range cases {
+		zfsTestExecutor := testExecutor{getSnapshotListResult: []byte(output)}
+		config := &conf.Config{
+			Plans: []conf.Plan{
+				{
+					Name:    "buh",
+					Paths:   []string{"playground/fs1"},
+					Latest:  1,
+					Periods: []conf.Period{{Frequency: time.Hour, Age: 48 * time.Hour}},
+					Anchor:  c.anchor,
+				},
+			},
+		}
+
+		lists, err := processAll(now, config, &zfsTestExecutor)
+		if err != nil {
+			t.Fatalf("%d processAll() returned error: %s", i, err.Error())
+		}
+
+		for j, snapshot := range lists[0].snapshots {
+			if snapshot.Keep != c.expected[j] {
+				t.Errorf("%d processAll() set Keep to %v for %s", i, snapshot.Keep, snapshot.Name)
+			}
+		}
+
+		if !lists[0].anchor.Equal(c.time) {
+			t.Errorf("%d processAll() anchored at %s, expected %s", i, lists[0].anchor, c.time)
+		}
+	}
+}
+
+func TestCleanPlansPauseIfStale(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fresh@snap1	1492989570
+playground/fresh@snap2	1492993000
+playground/stale@snap1	1492900000
+playground/stale@snap2	1492900001
+`),
+	}
+
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{
+				Name:         "buh",
+				Paths:        []string{"playground/fresh", "playground/stale"},
+				Latest:       1,
+				PauseIfStale: 6 * time.Hour,
+			},
+		},
+	}
+
+	err := cleanPlans(zfsTestExecutor, "test.conf", config)
+	if err == nil {
+		t.Fatalf("cleanPlans() did not err on stale dataset")
+	}
+
+	expected := []string{"playground/fresh@snap1"}
+	if !reflect.DeepEqual(zfsTestExecutor.destroyed, expected) {
+		t.Fatalf("cleanPlans() destroyed %v, expected %v", zfsTestExecutor.destroyed, expected)
+	}
+
+	lists, _ := processAll(now, config, zfsTestExecutor)
+	cases := []struct {
+		pauseIfStale time.Duration
+		stale        []bool
+	}{
+		{0, []bool{false, false}},
+		{time.Hour, []bool{false, true}},
+		{48 * time.Hour, []bool{false, false}},
+	}
+
+	for i, c := range cases {
+		for j, list := range lists {
+			list.plan.PauseIfStale = c.pauseIfStale
+			err := checkStale(list)
+			if (err != nil) != c.stale[j] {
+				t.Errorf("%d checkStale() returned %v for %s", i, err, list.dataset)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cego/zfs-cleaner/zfs"
+)
+
+// manifestVersion must be bumped whenever the manifest format changes in an
+// incompatible way.
+const manifestVersion = 1
+
+type (
+	// manifest is a saved list of snapshots to destroy. It is written by
+	// the plan command and consumed by the apply command.
+	manifest struct {
+		Version   int                `json:"version"`
+		Created   time.Time          `json:"created"`
+		Config    string             `json:"config"`
+		Snapshots []manifestSnapshot `json:"snapshots"`
+	}
+
+	// manifestSnapshot identifies a single snapshot to destroy. The GUID
+	// is used to make sure we never destroy a snapshot that was recreated
+	// under the same name after the manifest was written.
+	manifestSnapshot struct {
+		Name     string    `json:"name"`
+		GUID     string    `json:"guid"`
+		Creation time.Time `json:"creation"`
+	}
+)
+
+// newManifest creates a manifest of all snapshots not kept in lists.
+func newManifest(configPath string, lists []datasetList, zfsExecutor zfs.Executor) (*manifest, error) {
+	m := &manifest{
+		Version:   manifestVersion,
+		Created:   now,
+		Config:    configPath,
+		Snapshots: []manifestSnapshot{},
+	}
+	for _, list := range lists {
+		for _, snapshot := range list.snapshots {
+			// apply never destroys clones, so snapshots with
+			// clones are left out.
+			if snapshot.Keep || len(snapshot.Clones) > 0 {
+				continue
+			}
+			guid := snapshot.GUID
+			if guid == "" {
+				var err error
+				guid, err = zfsExecutor.GetGUID(snapshot.Name)
+				if err != nil {
+					return nil, err
+				}
+			}
+			m.Snapshots = append(m.Snapshots, manifestSnapshot{
+				Name:     snapshot.Name,
+				GUID:     guid,
+				Creation: snapshot.Creation,
+			})
+		}
+	}
+	return m, nil
+}
+
+// readManifest reads a manifest previously written by write.
+func readManifest(r io.Reader) (*manifest, error) {
+	m := &manifest{}
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(m)
+	if err != nil {
+		return nil, err
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d, expected %d", m.Version, manifestVersion)
+	}
+	return m, nil
+}
+
+// write will write m to w as indented JSON.
+func (m *manifest) write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m)
+}
+
+// verify makes sure every snapshot in m still exists with the same GUID.
+// Snapshots are listed once for each root of the datasets in m. All
+// snapshots are checked before returning, and the error will list every
+// snapshot that failed verification.
+func (m *manifest) verify(zfsExecutor zfs.Executor) error {
+	datasets := []string{}
+	for _, snapshot := range m.Snapshots {
+		datasets = append(datasets, strings.SplitN(snapshot.Name, "@", 2)[0])
+	}
+	guids := make(map[string]string)
+	for _, root := range zfs.Roots(datasets) {
+		list, err := zfsExecutor.ListSnapshots(root)
+		if err != nil {
+			return err
+		}
+		for _, snapshot := range list {
+			guids[snapshot.Name] = snapshot.GUID
+		}
+	}
+	problems := []string{}
+	for _, snapshot := range m.Snapshots {
+		guid, found := guids[snapshot.Name]
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: snapshot does not exist", snapshot.Name))
+			continue
+		}
+		if guid != snapshot.GUID {
+			problems = append(problems, fmt.Sprintf("%s: guid is %s, expected %s", snapshot.Name, guid, snapshot.GUID))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("plan is stale, refusing to apply:\n%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
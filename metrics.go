@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// datasetMetrics is the statistics for a single dataset from the last
+	// run of its plan.
+	datasetMetrics struct {
+		plan      string
+		dataset   string
+		seen      int
+		kept      int
+		destroyed int
+		failed    int
+		oldest    time.Time
+		newest    time.Time
+		// plans is the names of all plans processing the dataset.
+		plans []string
+	}
+
+	// metricsRegistry collects metrics across runs. It is safe for
+	// concurrent use.
+	metricsRegistry struct {
+		sync.Mutex
+		datasets map[string]*datasetMetrics
+		// lastSuccess is the time of the last successful run of each
+		// plan.
+		lastSuccess map[string]time.Time
+		// duration is the duration of the last run.
+		duration time.Duration
+	}
+)
+
+// lastSuccessMetric is the name of the metric persisted across runs in the
+// textfile.
+const lastSuccessMetric = "zfs_cleaner_last_success_timestamp_seconds"
+
+var (
+	metricsTextfile = ""
+	metrics         = newMetricsRegistry()
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&metricsTextfile, "metrics-textfile", "", "Write metrics in node_exporter textfile format to this path after each run")
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		datasets:    make(map[string]*datasetMetrics),
+		lastSuccess: make(map[string]time.Time),
+	}
+}
+
+// record will record the outcome of a run of plans processing lists.
+// destroyed and failed is the number of snapshots destroyed and failed to
+// destroy for each list, indexed like lists. Datasets recorded earlier for
+// any of the plans, but no longer processed, are forgotten.
+func (m *metricsRegistry) record(plans []string, lists []datasetList, destroyed []int, failed []int, start time.Time, duration time.Duration, success bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	ran := make(map[string]bool)
+	for _, name := range plans {
+		ran[name] = true
+	}
+	for _, list := range lists {
+		ran[list.plan.Name] = true
+		for _, name := range list.merged {
+			ran[name] = true
+		}
+	}
+
+	for key, d := range m.datasets {
+		for _, name := range d.plans {
+			if ran[name] {
+				delete(m.datasets, key)
+				break
+			}
+		}
+	}
+
+	for i, list := range lists {
+		d := &datasetMetrics{
+			plan:      list.planName(),
+			dataset:   list.dataset,
+			seen:      len(list.snapshots),
+			destroyed: destroyed[i],
+			failed:    failed[i],
+			plans:     list.merged,
+		}
+		if len(d.plans) == 0 {
+			d.plans = []string{list.plan.Name}
+		}
+		for _, snapshot := range list.snapshots {
+			if snapshot.Keep {
+				d.kept++
+			}
+		}
+		if oldest := list.snapshots.Oldest(); oldest != nil {
+			d.oldest = oldest.Creation
+			d.newest = list.snapshots.Latest().Creation
+		}
+		m.datasets[d.plan+"\x00"+d.dataset] = d
+	}
+
+	m.duration = duration
+	if success {
+		for name := range ran {
+			m.lastSuccess[name] = start.Add(duration)
+		}
+	}
+}
+
+// restore will read the last successful run of each plan in plans from
+// metrics previously written to r. Times already recorded are kept if newer.
+// This keeps the time across one-shot runs, which would lose it after a
+// failed run.
+func (m *metricsRegistry) restore(r io.Reader, plans []string) {
+	m.Lock()
+	defer m.Unlock()
+
+	known := make(map[string]bool)
+	for _, name := range plans {
+		known[name] = true
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, lastSuccessMetric+"{plan=") {
+			continue
+		}
+		i := strings.LastIndex(line, "} ")
+		if i < 0 {
+			continue
+		}
+		name, err := strconv.Unquote(line[len(lastSuccessMetric)+len("{plan=") : i])
+		if err != nil || !known[name] {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[i+2:], 64)
+		if err != nil {
+			continue
+		}
+		t := time.Unix(int64(value), 0)
+		if t.After(m.lastSuccess[name]) {
+			m.lastSuccess[name] = t
+		}
+	}
+}
+
+// escapeLabel escapes a label value for the Prometheus text format.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// write writes all metrics to w in the Prometheus text exposition format.
+// Ages are calculated relative to now.
+func (m *metricsRegistry) write(w io.Writer, now time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+
+	buffer := &bytes.Buffer{}
+
+	datasetKeys := make([]string, 0, len(m.datasets))
+	for key := range m.datasets {
+		datasetKeys = append(datasetKeys, key)
+	}
+	sort.Strings(datasetKeys)
+
+	planKeys := make([]string, 0, len(m.lastSuccess))
+	for key := range m.lastSuccess {
+		planKeys = append(planKeys, key)
+	}
+	sort.Strings(planKeys)
+
+	datasetGauges := []struct {
+		name  string
+		help  string
+		value func(d *datasetMetrics) (float64, bool)
+	}{
+		{"zfs_cleaner_snapshots", "Number of snapshots seen in the last run.", func(d *datasetMetrics) (float64, bool) {
+			return float64(d.seen), true
+		}},
+		{"zfs_cleaner_snapshots_kept", "Number of snapshots kept in the last run.", func(d *datasetMetrics) (float64, bool) {
+			return float64(d.kept), true
+		}},
+		{"zfs_cleaner_snapshots_destroyed", "Number of snapshots destroyed in the last run.", func(d *datasetMetrics) (float64, bool) {
+			return float64(d.destroyed), true
+		}},
+		{"zfs_cleaner_destroy_failures", "Number of snapshots that failed to be destroyed in the last run.", func(d *datasetMetrics) (float64, bool) {
+			return float64(d.failed), true
+		}},
+		{"zfs_cleaner_oldest_snapshot_age_seconds", "Age of the oldest snapshot.", func(d *datasetMetrics) (float64, bool) {
+			return now.Sub(d.oldest).Seconds(), !d.oldest.IsZero()
+		}},
+		{"zfs_cleaner_newest_snapshot_age_seconds", "Age of the newest snapshot.", func(d *datasetMetrics) (float64, bool) {
+			return now.Sub(d.newest).Seconds(), !d.newest.IsZero()
+		}},
+	}
+
+	for _, gauge := range datasetGauges {
+		fmt.Fprintf(buffer, "# HELP %s %s\n# TYPE %s gauge\n", gauge.name, gauge.help, gauge.name)
+		for _, key := range datasetKeys {
+			d := m.datasets[key]
+			value, ok := gauge.value(d)
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(buffer, "%s{plan=\"%s\",dataset=\"%s\"} %g\n", gauge.name, escapeLabel(d.plan), escapeLabel(d.dataset), value)
+		}
+	}
+
+	fmt.Fprintf(buffer, "# HELP zfs_cleaner_run_duration_seconds Duration of the last run.\n# TYPE zfs_cleaner_run_duration_seconds gauge\n")
+	fmt.Fprintf(buffer, "zfs_cleaner_run_duration_seconds %g\n", m.duration.Seconds())
+
+	fmt.Fprintf(buffer, "# HELP %s Time of the last successful run as a Unix timestamp.\n# TYPE %s gauge\n", lastSuccessMetric, lastSuccessMetric)
+	for _, key := range planKeys {
+		fmt.Fprintf(buffer, "%s{plan=\"%s\"} %d\n", lastSuccessMetric, escapeLabel(key), m.lastSuccess[key].Unix())
+	}
+
+	_, err := buffer.WriteTo(w)
+
+	return err
+}
+
+// writeTextfile will atomically replace path with the current metrics. The
+// last successful run of plans is kept from the file being replaced.
+func (m *metricsRegistry) writeTextfile(path string, plans []string, now time.Time) error {
+	previous, err := os.Open(path)
+	if err == nil {
+		m.restore(previous, plans)
+		previous.Close()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	err = m.write(f, now)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	// node_exporter must be able to read the file.
+	err = f.Chmod(0644)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// ServeHTTP implements http.Handler.
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_ = m.write(w, time.Now())
+}
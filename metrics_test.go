@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+	"github.com/cego/zfs-cleaner/zfs"
+)
+
+func testMetricsRegistry() *metricsRegistry {
+	s1 := &zfs.Snapshot{Name: "pool/fs1@s1", Creation: time.Unix(1000, 0)}
+	s2 := &zfs.Snapshot{Name: "pool/fs1@s2", Creation: time.Unix(2000, 0), Keep: true}
+	lists := []datasetList{
+		{
+			plan:      conf.Plan{Name: "buh"},
+			dataset:   "pool/fs1",
+			snapshots: zfs.SnapshotList{s1, s2},
+		},
+		{
+			plan:      conf.Plan{Name: "buh"},
+			dataset:   "pool/\"empty\"",
+			snapshots: zfs.SnapshotList{},
+		},
+	}
+
+	m := newMetricsRegistry()
+	m.record([]string{"buh"}, lists, []int{1, 0}, []int{0, 0}, time.Unix(3000, 0), 2*time.Second, true)
+
+	return m
+}
+
+func TestMetricsWrite(t *testing.T) {
+	m := testMetricsRegistry()
+
+	buffer := &bytes.Buffer{}
+	err := m.write(buffer, time.Unix(3600, 0))
+	if err != nil {
+		t.Fatalf("write() returned error: %s", err.Error())
+	}
+
+	out := buffer.String()
+	expected := []string{
+		`zfs_cleaner_snapshots{plan="buh",dataset="pool/fs1"} 2`,
+		`zfs_cleaner_snapshots{plan="buh",dataset="pool/\"empty\""} 0`,
+		`zfs_cleaner_snapshots_kept{plan="buh",dataset="pool/fs1"} 1`,
+		`zfs_cleaner_snapshots_destroyed{plan="buh",dataset="pool/fs1"} 1`,
+		`zfs_cleaner_destroy_failures{plan="buh",dataset="pool/fs1"} 0`,
+		`zfs_cleaner_oldest_snapshot_age_seconds{plan="buh",dataset="pool/fs1"} 2600`,
+		`zfs_cleaner_newest_snapshot_age_seconds{plan="buh",dataset="pool/fs1"} 1600`,
+		`zfs_cleaner_run_duration_seconds 2`,
+		`zfs_cleaner_last_success_timestamp_seconds{plan="buh"} 3002`,
+		`# TYPE zfs_cleaner_snapshots gauge`,
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(out, e+"\n") {
+			t.Errorf("write() did not output '%s':\n%s", e, out)
+		}
+	}
+
+	if strings.Contains(out, `oldest_snapshot_age_seconds{plan="buh",dataset="pool/\"empty\""}`) {
+		t.Errorf("write() returned age for dataset without snapshots")
+	}
+}
+
+func TestMetricsWriteTextfile(t *testing.T) {
+	m := testMetricsRegistry()
+
+	d, err := ioutil.TempDir("", "zfs-cleaner-test")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %s", err.Error())
+	}
+	defer os.RemoveAll(d)
+
+	path := filepath.Join(d, "zfs-cleaner.prom")
+	err = m.writeTextfile(path, []string{"buh"}, time.Unix(3600, 0))
+	if err != nil {
+		t.Fatalf("writeTextfile() returned error: %s", err.Error())
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read textfile: %s", err.Error())
+	}
+
+	if !strings.Contains(string(content), "zfs_cleaner_snapshots_kept") {
+		t.Fatalf("writeTextfile() wrote unexpected content: %s", string(content))
+	}
+
+	files, _ := ioutil.ReadDir(d)
+	if len(files) != 1 {
+		t.Fatalf("writeTextfile() left temporary files behind")
+	}
+}
+
+func TestMetricsServeHTTP(t *testing.T) {
+	m := testMetricsRegistry()
+
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.Contains(recorder.Body.String(), "zfs_cleaner_run_duration_seconds") {
+		t.Fatalf("ServeHTTP() returned unexpected body: %s", recorder.Body.String())
+	}
+}
+
+func TestMetricsRecordForgetsDatasets(t *testing.T) {
+	m := testMetricsRegistry()
+
+	lists := []datasetList{
+		{plan: conf.Plan{Name: "buh"}, dataset: "pool/fs1"},
+		{plan: conf.Plan{Name: "other"}, dataset: "pool/fs2"},
+	}
+	m.record([]string{"buh", "other"}, lists, []int{0, 0}, []int{0, 0}, time.Unix(4000, 0), time.Second, false)
+
+	buffer := &bytes.Buffer{}
+	_ = m.write(buffer, time.Unix(4000, 0))
+	out := buffer.String()
+
+	if strings.Contains(out, "empty") {
+		t.Errorf("write() kept a dataset no longer processed:\n%s", out)
+	}
+
+	if !strings.Contains(out, `zfs_cleaner_snapshots{plan="other",dataset="pool/fs2"} 0`) {
+		t.Errorf("write() did not output the new dataset:\n%s", out)
+	}
+
+	if !strings.Contains(out, `zfs_cleaner_last_success_timestamp_seconds{plan="buh"} 3002`) || strings.Contains(out, `last_success_timestamp_seconds{plan="other"}`) {
+		t.Errorf("write() returned wrong last success after a failed run:\n%s", out)
+	}
+}
+
+func TestMetricsTextfileKeepsLastSuccess(t *testing.T) {
+	d, err := ioutil.TempDir("", "zfs-cleaner-test")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %s", err.Error())
+	}
+	defer os.RemoveAll(d)
+
+	path := filepath.Join(d, "zfs-cleaner.prom")
+	err = testMetricsRegistry().writeTextfile(path, []string{"buh"}, time.Unix(3600, 0))
+	if err != nil {
+		t.Fatalf("writeTextfile() returned error: %s", err.Error())
+	}
+
+	// A new process with a failed run, like a one-shot clean.
+	m := newMetricsRegistry()
+	lists := []datasetList{{plan: conf.Plan{Name: "buh"}, dataset: "pool/fs1"}}
+	m.record([]string{"buh"}, lists, []int{0}, []int{1}, time.Unix(5000, 0), time.Second, false)
+	err = m.writeTextfile(path, []string{"buh"}, time.Unix(5000, 0))
+	if err != nil {
+		t.Fatalf("writeTextfile() returned error: %s", err.Error())
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read textfile: %s", err.Error())
+	}
+
+	if !strings.Contains(string(content), `zfs_cleaner_last_success_timestamp_seconds{plan="buh"} 3002`+"\n") {
+		t.Fatalf("writeTextfile() did not keep the last success:\n%s", string(content))
+	}
+
+	// Plans no longer configured are dropped.
+	m = newMetricsRegistry()
+	err = m.writeTextfile(path, []string{"other"}, time.Unix(5000, 0))
+	if err != nil {
+		t.Fatalf("writeTextfile() returned error: %s", err.Error())
+	}
+
+	content, _ = ioutil.ReadFile(path)
+	if strings.Contains(string(content), `{plan="buh"}`) {
+		t.Fatalf("writeTextfile() kept the last success of a removed plan:\n%s", string(content))
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cego/zfs-cleaner/zfs"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputJSONL = "jsonl"
+)
+
+type (
+	// record is a single machine-readable output record used when output
+	// is json or jsonl.
+	record struct {
+		Action   string     `json:"action"`
+		Plan     string     `json:"plan,omitempty"`
+		Dataset  string     `json:"dataset,omitempty"`
+		Snapshot string     `json:"snapshot,omitempty"`
+		Creation *time.Time `json:"creation,omitempty"`
+		// Age is the age of the snapshot in seconds.
+		Age     *int64   `json:"age,omitempty"`
+		Reasons []string `json:"reasons,omitempty"`
+		Command string   `json:"command,omitempty"`
+		Error   string   `json:"error,omitempty"`
+		Message string   `json:"message,omitempty"`
+		// Anchor is the name and time of the point periods was
+		// measured from.
+		Anchor     string     `json:"anchor,omitempty"`
+		AnchorTime *time.Time `json:"anchor_time,omitempty"`
+		// Bytes is the space reclaimed or reclaimable.
+		Bytes *uint64 `json:"bytes,omitempty"`
+		// Status, Destroyed and Failed summarizes the outcome for a
+		// dataset.
+		Status    string `json:"status,omitempty"`
+		Destroyed *int   `json:"destroyed,omitempty"`
+		Failed    *int   `json:"failed,omitempty"`
+	}
+)
+
+const (
+	actionKeep          = "keep"
+	actionDestroy       = "destroy"
+	actionWouldDestroy  = "would-destroy"
+	actionDestroyed     = "destroyed"
+	actionDestroyFailed = "destroy-failed"
+	actionError         = "error"
+	actionUnplanned     = "unplanned"
+	actionUnmanaged     = "unmanaged"
+	actionInfo          = "info"
+	actionReclaim       = "reclaim"
+	actionSummary       = "summary"
+	actionFreshness     = "freshness"
+)
+
+var (
+	outputFormat = outputText
+	// records is buffered until flushOutput when output is json.
+	records = []record{}
+	// recordsLock protects records and structured output, errors can be
+	// reported from the metrics server of the daemon.
+	recordsLock sync.Mutex
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "", outputText, "Output format: text, json or jsonl")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		switch outputFormat {
+		case outputText, outputJSON, outputJSONL:
+			return nil
+		}
+		return fmt.Errorf("unknown output format '%s'", outputFormat)
+	}
+}
+
+// structuredOutput returns true if output should be records instead of text.
+func structuredOutput() bool {
+	return outputFormat == outputJSON || outputFormat == outputJSONL
+}
+
+// snapshotRecord returns a record describing action for snapshot.
+func snapshotRecord(action string, plan string, snapshot *zfs.Snapshot) record {
+	creation := snapshot.Creation
+	age := int64(now.Sub(creation).Seconds())
+	r := record{
+		Action:   action,
+		Plan:     plan,
+		Dataset:  snapshot.DatasetName(),
+		Snapshot: snapshot.Name,
+		Creation: &creation,
+		Age:      &age,
+	}
+	for _, reason := range snapshot.Reasons {
+		r.Reasons = append(r.Reasons, reason.String())
+	}
+	return r
+}
+
+// listRecord returns a record describing action for snapshot in list,
+// including the anchor used.
+func listRecord(action string, list datasetList, snapshot *zfs.Snapshot) record {
+	r := snapshotRecord(action, list.planName(), snapshot)
+	anchor := list.anchor
+	r.Anchor = list.anchorName()
+	r.AnchorTime = &anchor
+	return r
+}
+
+// emit will output r if output is structured. In text mode this does
+// nothing.
+func emit(r record) {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+	switch outputFormat {
+	case outputJSONL:
+		_ = json.NewEncoder(stdout).Encode(r)
+	case outputJSON:
+		records = append(records, r)
+	}
+}
+
+// info prints a message for the user in text mode, and emits an info record
+// otherwise.
+func info(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if structuredOutput() {
+		emit(record{Action: actionInfo, Message: message})
+		return
+	}
+	fmt.Fprintf(stdout, "%s\n", message)
+}
+
+// reportError will report a non-fatal error related to dataset.
+func reportError(dataset string, err error) {
+	if structuredOutput() {
+		emit(record{Action: actionError, Dataset: dataset, Error: err.Error()})
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+}
+
+// flushOutput will write all buffered records when output is json.
+func flushOutput() {
+	if outputFormat != outputJSON {
+		return
+	}
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(records)
+	records = []record{}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+	"github.com/cego/zfs-cleaner/zfs"
+)
+
+func withOutput(format string, f func(buffer *bytes.Buffer)) {
+	buffer := &bytes.Buffer{}
+	stdout = buffer
+	outputFormat = format
+	defer func() {
+		stdout = ioutil.Discard
+		outputFormat = outputText
+		records = []record{}
+	}()
+
+	f(buffer)
+}
+
+func TestOutputJSONL(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570
+playground/fs1@snap2	1492989572
+`),
+	}
+
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{
+				Name:   "buh",
+				Paths:  []string{"playground/fs1"},
+				Latest: 1,
+			},
+		},
+	}
+
+	withOutput(outputJSONL, func(buffer *bytes.Buffer) {
+		err := cleanPlans(zfsTestExecutor, "test.conf", config)
+		if err != nil {
+			t.Fatalf("cleanPlans() returned error: %s", err.Error())
+		}
+
+		got := []record{}
+		scanner := bufio.NewScanner(buffer)
+		for scanner.Scan() {
+			r := record{}
+			err := json.Unmarshal(scanner.Bytes(), &r)
+			if err != nil {
+				t.Fatalf("Failed to parse '%s': %s", scanner.Text(), err.Error())
+			}
+			got = append(got, r)
+		}
+
+		expected := []struct {
+			action   string
+			snapshot string
+		}{
+			{actionDestroy, "playground/fs1@snap1"},
+			{actionKeep, "playground/fs1@snap2"},
+			{actionDestroyed, "playground/fs1@snap1"},
+		}
+
+		if len(got) != len(expected) {
+			t.Fatalf("Got %d records, expected %d: %+v", len(got), len(expected), got)
+		}
+
+		for i, e := range expected {
+			r := got[i]
+			if r.Action != e.action || r.Snapshot != e.snapshot || r.Plan != "buh" || r.Dataset != "playground/fs1" {
+				t.Errorf("%d Got unexpected record %+v", i, r)
+			}
+		}
+
+		if got[0].Age == nil || *got[0].Age != 3849 {
+			t.Errorf("Got wrong age in record: %+v", got[0])
+		}
+
+		if len(got[1].Reasons) != 1 || got[1].Reasons[0] != "latest: latest 1" {
+			t.Errorf("Got wrong reasons in record: %+v", got[1])
+		}
+	})
+}
+
+func TestOutputJSON(t *testing.T) {
+	withOutput(outputJSON, func(buffer *bytes.Buffer) {
+		info("hello %s", "world")
+		emit(record{Action: actionUnplanned, Dataset: "playground/fs2"})
+
+		if buffer.Len() != 0 {
+			t.Fatalf("json output was not buffered")
+		}
+
+		flushOutput()
+
+		got := []record{}
+		err := json.Unmarshal(buffer.Bytes(), &got)
+		if err != nil {
+			t.Fatalf("Failed to parse output: %s", err.Error())
+		}
+
+		if len(got) != 2 || got[0].Message != "hello world" || got[1].Action != actionUnplanned {
+			t.Fatalf("Got unexpected records: %+v", got)
+		}
+	})
+}
+
+func TestListRecordAnchor(t *testing.T) {
+	anchor := time.Unix(1492989572, 0)
+	list := datasetList{
+		plan:   conf.Plan{Name: "buh", Anchor: conf.AnchorNewest},
+		anchor: anchor,
+	}
+	snapshot, _ := zfs.NewSnapshotFromLine("playground/fs1@snap1 1492989570")
+
+	r := listRecord(actionKeep, list, snapshot)
+	if r.Anchor != conf.AnchorNewest || !r.AnchorTime.Equal(anchor) {
+		t.Fatalf("listRecord() returned anchor %s %v", r.Anchor, r.AnchorTime)
+	}
+
+	list.plan.Anchor = ""
+	r = listRecord(actionKeep, list, snapshot)
+	if r.Anchor != conf.AnchorNow {
+		t.Fatalf("listRecord() returned anchor %s, expected %s", r.Anchor, conf.AnchorNow)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+	"github.com/cego/zfs-cleaner/zfs"
+)
+
+// planName returns the name of the plan of l. For merged lists the names of
+// all plans are joined by "+".
+func (l datasetList) planName() string {
+	if len(l.merged) == 0 {
+		return l.plan.Name
+	}
+	return strings.Join(l.merged, "+")
+}
+
+// overlapConflicts returns an error for every dataset resolved by more than
+// one plan.
+func overlapConflicts(plans []conf.Plan, resolved [][]string) []error {
+	names := make(map[string][]string)
+	order := []string{}
+	for i, datasets := range resolved {
+		for _, dataset := range datasets {
+			if _, found := names[dataset]; !found {
+				order = append(order, dataset)
+			}
+			names[dataset] = append(names[dataset], plans[i].Name)
+		}
+	}
+	conflicts := []error{}
+	for _, dataset := range order {
+		if len(names[dataset]) > 1 {
+			conflicts = append(conflicts, overlapError(dataset, names[dataset]))
+		}
+	}
+	return conflicts
+}
+
+// overlapError returns the error used when dataset is in more than one plan.
+func overlapError(dataset string, plans []string) error {
+	return fmt.Errorf("dataset %s is in plans %s. Use 'overlap union' to merge them", dataset, strings.Join(plans, ", "))
+}
+
+// mergeOverlaps applies the overlap policy of config to datasets present in
+// more than one list. snapshots is all snapshots discovered, by dataset.
+// Lists are returned in the order each dataset was first seen. With the
+// error policy, only the datasets in more than one plan fail, unless
+// running with --fail-fast.
+func mergeOverlaps(config *conf.Config, lists []datasetList, snapshots map[string]zfs.SnapshotList) ([]datasetList, error) {
+	groups := make(map[string][]datasetList)
+	order := []string{}
+	for _, list := range lists {
+		if _, found := groups[list.dataset]; !found {
+			order = append(order, list.dataset)
+		}
+		groups[list.dataset] = append(groups[list.dataset], list)
+	}
+	if len(order) == len(lists) {
+		return lists, nil
+	}
+	merged := make([]datasetList, 0, len(order))
+	for _, dataset := range order {
+		group := groups[dataset]
+		if len(group) == 1 {
+			merged = append(merged, group[0])
+			continue
+		}
+		if config.OverlapPolicy() == conf.OverlapError {
+			names := make([]string, len(group))
+			for i, list := range group {
+				names[i] = list.plan.Name
+			}
+			err := overlapError(dataset, names)
+			if failFast {
+				return nil, err
+			}
+			reportError(dataset, err)
+			merged = append(merged, datasetList{
+				plan:    group[0].plan,
+				dataset: dataset,
+				anchor:  group[0].anchor,
+				merged:  names,
+				err:     err,
+			})
+			continue
+		}
+		merged = append(merged, unionLists(config, snapshots[dataset], group))
+	}
+	return merged, nil
+}
+
+// unionLists merges lists of the same dataset. A snapshot is kept if any
+// plan keeps it, and managed if any plan manages it. Every reason records
+// the plan it came from. If any plan failed, the merged list fails too.
+// Settings applied after merging are taken from the strictest plan, see
+// strictestPlan.
+func unionLists(config *conf.Config, all zfs.SnapshotList, group []datasetList) datasetList {
+	result := group[0]
+	result.snapshots = zfs.SnapshotList{}
+	result.unmanaged = zfs.SnapshotList{}
+	result.merged = make([]string, len(group))
+	result.uncommon = nil
+	for i, list := range group {
+		result.merged[i] = list.plan.Name
+		result.uncommon = append(result.uncommon, list.uncommon...)
+		if list.err != nil {
+			// Without every plan we cannot know what to keep.
+			result.err = list.err
+		}
+	}
+	if result.err != nil {
+		return result
+	}
+	plan, err := strictestPlan(config, group)
+	if err != nil {
+		reportError(result.dataset, err)
+		result.err = err
+		return result
+	}
+	result.plan = plan
+	managed := make([]map[string]*zfs.Snapshot, len(group))
+	for i, list := range group {
+		managed[i] = make(map[string]*zfs.Snapshot)
+		for _, snapshot := range list.snapshots {
+			managed[i][snapshot.Name] = snapshot
+		}
+	}
+	for _, s := range all {
+		snapshot := *s
+		snapshot.Keep = false
+		snapshot.Reasons = nil
+		found := false
+		for i, list := range group {
+			m, ok := managed[i][snapshot.Name]
+			if !ok {
+				continue
+			}
+			found = true
+			snapshot.Keep = snapshot.Keep || m.Keep
+			for _, reason := range m.Reasons {
+				reason.Plan = list.plan.Name
+				snapshot.Reasons = append(snapshot.Reasons, reason)
+			}
+		}
+		if found {
+			result.snapshots = append(result.snapshots, &snapshot)
+		} else {
+			result.unmanaged = append(result.unmanaged, &snapshot)
+		}
+	}
+	return result
+}
+
+// strictestPlan returns the plan of the first list in group, with the
+// settings applied after merging replaced by the strictest of all plans in
+// group: the lowest destroy limits, pause-if-stale and expect-every, the
+// highest target-free, the lowest max-snapshot-usage and the clone policy
+// destroying the least. Plans using different anchors cannot be merged, and
+// an error is returned.
+func strictestPlan(config *conf.Config, group []datasetList) (conf.Plan, error) {
+	plan := group[0].plan
+	for _, list := range group[1:] {
+		if list.anchorName() != group[0].anchorName() {
+			return plan, fmt.Errorf("dataset %s is in plans %s and %s using different anchors, refusing to merge them", list.dataset, group[0].plan.Name, list.plan.Name)
+		}
+	}
+	// Clone policies from least to most strict.
+	clones := []string{conf.ClonesDestroy, conf.ClonesKeep, conf.ClonesError}
+	rank := func(p conf.Plan) int {
+		for i, policy := range clones {
+			if p.ClonePolicy() == policy {
+				return i
+			}
+		}
+		return 0
+	}
+	plan.MaxDestroy, plan.MaxDestroyPercent = config.DestroyLimits(&plan)
+	for _, list := range group[1:] {
+		other := list.plan
+		maxDestroy, maxDestroyPercent := config.DestroyLimits(&other)
+		plan.MaxDestroy = int(lowestLimit(int64(plan.MaxDestroy), int64(maxDestroy)))
+		plan.MaxDestroyPercent = int(lowestLimit(int64(plan.MaxDestroyPercent), int64(maxDestroyPercent)))
+		plan.PauseIfStale = time.Duration(lowestLimit(int64(plan.PauseIfStale), int64(other.PauseIfStale)))
+		plan.ExpectEvery = time.Duration(lowestLimit(int64(plan.ExpectEvery), int64(other.ExpectEvery)))
+		if other.MaxSnapshotUsage > 0 && (plan.MaxSnapshotUsage == 0 || other.MaxSnapshotUsage < plan.MaxSnapshotUsage) {
+			plan.MaxSnapshotUsage = other.MaxSnapshotUsage
+		}
+		if other.TargetFreePercent > plan.TargetFreePercent {
+			plan.TargetFreePercent = other.TargetFreePercent
+		}
+		if rank(other) > rank(plan) {
+			plan.Clones = other.Clones
+			plan.CloneAllowlist = other.CloneAllowlist
+		}
+	}
+	return plan, nil
+}
+
+// lowestLimit returns the lowest of a and b, where zero means no limit.
+func lowestLimit(a int64, b int64) int64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
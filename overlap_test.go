@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+)
+
+func TestProcessAllOverlap(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570
+playground/fs1@manual	1492989571
+playground/fs1@snap3	1492989572
+playground/fs1@snap4	1492989573
+playground/fs2@snap1	1492989574
+`),
+	}
+	plans := []conf.Plan{
+		{
+			Name:   "a",
+			Paths:  []string{"playground/fs1", "playground/fs2"},
+			Latest: 2,
+			Match:  []conf.Pattern{{Kind: conf.PatternGlob, Expr: "snap*"}},
+		},
+		{
+			Name:    "b",
+			Paths:   []string{"playground/fs1"},
+			Protect: []string{"snap1"},
+		},
+	}
+
+	// Only the overlapping dataset fails.
+	lists, err := processAll(now, &conf.Config{Plans: plans}, zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+	if len(lists) != 2 || lists[0].err == nil || !strings.Contains(lists[0].err.Error(), "dataset playground/fs1 is in plans a, b") {
+		t.Fatalf("processAll() did not refuse overlapping plans, got %+v", lists)
+	}
+	if lists[1].err != nil || len(lists[1].snapshots) != 1 {
+		t.Fatalf("processAll() failed %s without overlap", lists[1].dataset)
+	}
+
+	failFast = true
+	_, err = processAll(now, &conf.Config{Plans: plans}, zfsTestExecutor)
+	failFast = false
+	if err == nil || !strings.Contains(err.Error(), "dataset playground/fs1 is in plans a, b") {
+		t.Fatalf("processAll() did not abort on overlapping plans with fail-fast, got %v", err)
+	}
+
+	lists, err = processAll(now, &conf.Config{Plans: plans, Overlap: conf.OverlapUnion}, zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+
+	if len(lists) != 2 {
+		t.Fatalf("processAll() returned %d lists, expected 2", len(lists))
+	}
+
+	merged := lists[0]
+	if merged.dataset != "playground/fs1" || merged.planName() != "a+b" {
+		t.Fatalf("processAll() returned %s for plan %s", merged.dataset, merged.planName())
+	}
+
+	if len(merged.unmanaged) != 0 {
+		t.Fatalf("processAll() left %s unmanaged, plan b manages everything", merged.unmanaged.String())
+	}
+
+	expected := "[ playground/fs1@snap1:1492989570:true playground/fs1@manual:1492989571:false playground/fs1@snap3:1492989572:true playground/fs1@snap4:1492989573:true ]"
+	if merged.snapshots.String() != expected {
+		t.Fatalf("processAll() returned %s, expected %s", merged.snapshots.String(), expected)
+	}
+
+	reasons := []string{}
+	for _, reason := range merged.snapshots[0].Reasons {
+		reasons = append(reasons, reason.String())
+	}
+	if !reflect.DeepEqual(reasons, []string{"protect: snap1 (plan b)"}) {
+		t.Fatalf("processAll() recorded reasons %v", reasons)
+	}
+
+	if lists[1].dataset != "playground/fs2" || lists[1].planName() != "a" {
+		t.Fatalf("processAll() returned %s for plan %s", lists[1].dataset, lists[1].planName())
+	}
+}
+
+func TestStrictestPlan(t *testing.T) {
+	config := &conf.Config{MaxDestroy: 10, Overlap: conf.OverlapUnion}
+	group := []datasetList{
+		{plan: conf.Plan{Name: "a", MaxDestroyPercent: 50, PauseIfStale: time.Hour, TargetFreePercent: 10, Clones: conf.ClonesDestroy}},
+		{plan: conf.Plan{Name: "b", MaxDestroy: 20, PauseIfStale: 2 * time.Hour, MaxSnapshotUsage: 1000, Clones: conf.ClonesError}},
+		{plan: conf.Plan{Name: "c", MaxDestroy: 5, MaxDestroyPercent: 30, TargetFreePercent: 20, MaxSnapshotUsage: 2000}},
+	}
+
+	for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}, {1, 2, 0}} {
+		ordered := []datasetList{}
+		for _, i := range order {
+			ordered = append(ordered, group[i])
+		}
+
+		plan, err := strictestPlan(config, ordered)
+		if err != nil {
+			t.Fatalf("%v strictestPlan() returned error: %s", order, err.Error())
+		}
+
+		if plan.MaxDestroy != 5 || plan.MaxDestroyPercent != 30 || plan.PauseIfStale != time.Hour || plan.TargetFreePercent != 20 || plan.MaxSnapshotUsage != 1000 || plan.ClonePolicy() != conf.ClonesError {
+			t.Errorf("%v strictestPlan() returned %+v", order, plan)
+		}
+	}
+
+	group[1].plan.Anchor = conf.AnchorNewest
+	_, err := strictestPlan(config, group)
+	if err == nil {
+		t.Fatalf("strictestPlan() did not refuse plans with different anchors")
+	}
+}
+
+func TestCleanPlansOverlap(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570
+playground/fs1@snap2	1492989571
+playground/fs1@snap3	1492989572
+`),
+	}
+	config := &conf.Config{
+		Overlap: conf.OverlapUnion,
+		Plans: []conf.Plan{
+			{Name: "a", Paths: []string{"playground/fs1"}, Latest: 1},
+			{Name: "b", Paths: []string{"playground/fs1"}, Protect: []string{"snap1"}},
+		},
+	}
+
+	verbose = true
+	defer func() { verbose = false }()
+	withOutput(outputText, func(buffer *bytes.Buffer) {
+		err := cleanPlans(zfsTestExecutor, "test.conf", config)
+		if err != nil {
+			t.Fatalf("cleanPlans() returned error: %s", err.Error())
+		}
+
+		if !reflect.DeepEqual(zfsTestExecutor.destroyed, []string{"playground/fs1@snap2"}) {
+			t.Fatalf("cleanPlans() destroyed %v", zfsTestExecutor.destroyed)
+		}
+
+		for _, line := range []string{
+			"### Keep playground/fs1@snap1 (Age 1h4m9s): protect: snap1 (plan b)",
+			"### Keep playground/fs1@snap3 (Age 1h4m7s): latest: latest 1 (plan a)",
+		} {
+			if !strings.Contains(buffer.String(), line) {
+				t.Errorf("cleanPlans() did not print '%s':\n%s", line, buffer.String())
+			}
+		}
+	})
+}
+
+func TestPlanCheckOverlap(t *testing.T) {
+	zfsTestExecutor := &testExecutor{
+		filesystems: []byte("playground/fs1\nplayground/fs2\n"),
+	}
+	plans := []conf.Plan{
+		{Name: "a", Paths: []string{"playground/fs1", "playground/fs2"}},
+		{Name: "b", Paths: []string{"playground/fs1"}},
+	}
+
+	cases := []struct {
+		overlap  string
+		expected string
+	}{
+		{"", "Conflict: dataset playground/fs1 is in plans a, b. Use 'overlap union' to merge them\n"},
+		{conf.OverlapUnion, ""},
+	}
+
+	for i, c := range cases {
+		withOutput(outputText, func(buffer *bytes.Buffer) {
+			err := planCheck(zfsTestExecutor, &conf.Config{Plans: plans, Overlap: c.overlap}, false)
+			if err != nil {
+				t.Fatalf("%d planCheck() returned error: %s", i, err.Error())
+			}
+
+			if buffer.String() != c.expected {
+				t.Errorf("%d planCheck() printed '%s', expected '%s'", i, buffer.String(), c.expected)
+			}
+		})
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"github.com/cego/zfs-cleaner/zfs"
-	"os"
 	"strings"
 
 	"github.com/cego/zfs-cleaner/conf"
@@ -19,12 +18,7 @@ func AddPlanCheckCommand(zfsExecutor zfs.Executor) {
 			if len(args) != 1 {
 				return fmt.Errorf("%s /path/to/config.config", cmd.Name())
 			}
-			configFile, err := os.Open(args[0])
-			if err != nil {
-				return fmt.Errorf("failed to open %s: %s", args[0], err.Error())
-			}
-			defer configFile.Close()
-			config, err := readConfig(configFile)
+			config, err := loadConfig(args[0])
 			if err != nil {
 				return err
 			}
@@ -43,23 +37,42 @@ func hasSnapshots(zfsExecutor zfs.Executor, dataset string) bool {
 	return hasSnapshot
 }
 
-func planCheck(zfsExecutor zfs.Executor, conf *conf.Config, ignoreEmpty bool) error {
+func planCheck(zfsExecutor zfs.Executor, config *conf.Config, ignoreEmpty bool) error {
 	output, err := zfsExecutor.GetFilesystems()
 	if err != nil {
 		return err
 	}
+	filesystems := strings.Fields(string(output))
+	plans, resolved, conflicts, err := resolvePlans(zfsExecutor, config, true)
+	if err != nil {
+		return err
+	}
+	if config.OverlapPolicy() == conf.OverlapError {
+		conflicts = append(conflicts, overlapConflicts(plans, resolved)...)
+	}
+	for _, conflict := range conflicts {
+		if structuredOutput() {
+			emit(record{Action: actionError, Error: conflict.Error()})
+			continue
+		}
+		fmt.Fprintf(stdout, "Conflict: %s\n", conflict.Error())
+	}
 	m := map[string]bool{}
-	for _, plan := range conf.Plans {
-		for _, path := range plan.Paths {
-			m[path] = true
+	for _, datasets := range resolved {
+		for _, dataset := range datasets {
+			m[dataset] = true
 		}
 	}
-	for _, store := range strings.Fields(string(output)) {
+	for _, store := range filesystems {
 		if !m[store] {
 			if ignoreEmpty && !hasSnapshots(zfsExecutor, store) {
 				continue
 			}
-			fmt.Printf("No plan found for path: '%s'\n", store)
+			if structuredOutput() {
+				emit(record{Action: actionUnplanned, Dataset: store})
+				continue
+			}
+			fmt.Fprintf(stdout, "No plan found for path: '%s'\n", store)
 		}
 	}
 	return nil
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cego/zfs-cleaner/conf"
+	"github.com/cego/zfs-cleaner/zfs"
+)
+
+// assignment is a plan assigned to a dataset using user properties. Only one
+// of plan and keep is set.
+type assignment struct {
+	dataset string
+
+	// plan is the name of a plan from the configuration file.
+	plan string
+
+	// keep is a plan built from inline keep rules.
+	keep *conf.Plan
+}
+
+// readAssignments reads plan assignments from the user properties of all
+// filesystems. If both properties apply to a dataset, the one set closest to
+// the dataset is used. Datasets with both properties set on the same dataset
+// or with unusable properties are returned as conflicts and left out.
+func readAssignments(zfsExecutor zfs.Executor, config *conf.Config) ([]assignment, []error, error) {
+	planProperty := config.PropertyName(conf.PropertyPlan)
+	keepProperty := config.PropertyName(conf.PropertyKeep)
+	properties, err := zfsExecutor.GetUserProperties([]string{planProperty, keepProperty})
+	if err != nil {
+		return nil, nil, err
+	}
+	// Collect both properties per dataset, preserving the order from zfs.
+	datasets := []string{}
+	values := make(map[string]map[string]zfs.Property)
+	for _, property := range properties {
+		if values[property.Dataset] == nil {
+			datasets = append(datasets, property.Dataset)
+			values[property.Dataset] = make(map[string]zfs.Property)
+		}
+		values[property.Dataset][property.Name] = property
+	}
+	// Plans from keep properties are shared by all datasets using the same
+	// value.
+	keepPlans := make(map[string]*conf.Plan)
+	assignments := []assignment{}
+	conflicts := []error{}
+	for _, dataset := range datasets {
+		planValue, hasPlan := values[dataset][planProperty]
+		keepValue, hasKeep := values[dataset][keepProperty]
+		if hasPlan && hasKeep {
+			// The property set closest to the dataset wins. Sources
+			// are the dataset or its ancestors, so the longest name
+			// is the closest.
+			switch {
+			case len(planValue.Source) > len(keepValue.Source):
+				hasKeep = false
+			case len(keepValue.Source) > len(planValue.Source):
+				hasPlan = false
+			}
+		}
+		plan := planValue.Value
+		keep := keepValue.Value
+		switch {
+		case hasPlan && hasKeep:
+			conflicts = append(conflicts, fmt.Errorf("%s: both %s and %s are set on %s", dataset, planProperty, keepProperty, planValue.Source))
+		case hasPlan:
+			assignments = append(assignments, assignment{dataset: dataset, plan: plan})
+		case hasKeep:
+			p, found := keepPlans[keep]
+			if !found {
+				parsed, err := conf.ParseKeepProperty(keepProperty+"="+keep, dataset, keep)
+				if err != nil {
+					conflicts = append(conflicts, fmt.Errorf("%s: %s=%s: %s", dataset, keepProperty, keep, err.Error()))
+					continue
+				}
+				p = &parsed
+				keepPlans[keep] = p
+			}
+			assignments = append(assignments, assignment{dataset: dataset, keep: p})
+		}
+	}
+	return assignments, conflicts, nil
+}
+
+// resolvePlans returns all plans to run, together with the datasets each
+// plan applies to. Besides the plans of config, plans built from keep
+// properties are included. Datasets listed in the configuration file take
+// precedence over properties, any such conflict is returned. Assignments to
+// plans not in config are ignored, unless strict is true, in which case they
+// are returned as conflicts as well.
+func resolvePlans(zfsExecutor zfs.Executor, config *conf.Config, strict bool) ([]conf.Plan, [][]string, []error, error) {
+	plans := append([]conf.Plan{}, config.Plans...)
+	resolved, err := resolvePaths(zfsExecutor, config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if config.Properties == "" {
+		return plans, resolved, nil, nil
+	}
+	assignments, conflicts, err := readAssignments(zfsExecutor, config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// Index datasets from the configuration file by plan.
+	fromFile := make(map[string]string)
+	for i, datasets := range resolved {
+		for _, dataset := range datasets {
+			fromFile[dataset] = plans[i].Name
+		}
+	}
+	index := make(map[string]int)
+	for i, plan := range plans {
+		index[plan.Name] = i
+	}
+	for _, a := range assignments {
+		name := a.plan
+		if a.keep != nil {
+			name = a.keep.Name
+		}
+		if planName, found := fromFile[a.dataset]; found {
+			if planName != name {
+				conflicts = append(conflicts, fmt.Errorf("%s: assigned to plan %s by configuration and %s by property", a.dataset, planName, name))
+			}
+			continue
+		}
+		i, found := index[name]
+		if !found {
+			if a.keep == nil {
+				if strict {
+					conflicts = append(conflicts, fmt.Errorf("%s: unknown plan %s", a.dataset, a.plan))
+				}
+				continue
+			}
+			i = len(plans)
+			index[name] = i
+			plans = append(plans, *a.keep)
+			resolved = append(resolved, nil)
+		}
+		resolved[i] = append(resolved[i], a.dataset)
+	}
+	return plans, resolved, conflicts, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+)
+
+var testProperties = []byte("pool\tcego:zfs-cleaner:plan\t-\t-\n" +
+	"pool\tcego:zfs-cleaner:keep\t-\t-\n" +
+	"pool/a\tcego:zfs-cleaner:plan\tlonglived\tlocal\n" +
+	"pool/a/b\tcego:zfs-cleaner:plan\tlonglived\tinherited from pool/a\n" +
+	"pool/c\tcego:zfs-cleaner:keep\tlatest 2\tlocal\n" +
+	"pool/d\tcego:zfs-cleaner:keep\tlatest 2\tlocal\n" +
+	"pool/e\tcego:zfs-cleaner:plan\tlonglived\tlocal\n" +
+	"pool/e\tcego:zfs-cleaner:keep\tlatest 2\tlocal\n" +
+	"pool/f\tcego:zfs-cleaner:plan\tshortlived\tlocal\n" +
+	"pool/g\tcego:zfs-cleaner:plan\tmissing\tlocal\n" +
+	"pool/h\tcego:zfs-cleaner:keep\t1h for 1m\tlocal\n" +
+	"pool/i\tcego:zfs-cleaner:keep\tlatest 2\tlocal\n" +
+	"pool/i/j\tcego:zfs-cleaner:plan\tlonglived\tlocal\n" +
+	"pool/i/j\tcego:zfs-cleaner:keep\tlatest 2\tinherited from pool/i\n")
+
+func testPropertiesConfig() *conf.Config {
+	return &conf.Config{
+		Properties: "cego:zfs-cleaner",
+		Plans: []conf.Plan{
+			{Name: "longlived", Latest: 10},
+			{Name: "shortlived", Paths: []string{"pool/f", "pool/a/b"}, Latest: 1},
+		},
+	}
+}
+
+func TestResolvePlans(t *testing.T) {
+	zfsTestExecutor := &testExecutor{properties: testProperties}
+
+	plans, resolved, conflicts, err := resolvePlans(zfsTestExecutor, testPropertiesConfig(), false)
+	if err != nil {
+		t.Fatalf("resolvePlans() returned error: %s", err.Error())
+	}
+
+	if len(plans) != 3 || plans[2].Name != "cego:zfs-cleaner:keep=latest 2" || plans[2].Latest != 2 {
+		t.Fatalf("resolvePlans() returned unexpected plans: %+v", plans)
+	}
+
+	// pool/i/j sets a plan, overriding the keep rules inherited.
+	expected := [][]string{
+		{"pool/a", "pool/i/j"},
+		{"pool/f", "pool/a/b"},
+		{"pool/c", "pool/d", "pool/i"},
+	}
+	if !reflect.DeepEqual(resolved, expected) {
+		t.Fatalf("resolvePlans() resolved %v, expected %v", resolved, expected)
+	}
+
+	expectedConflicts := []string{
+		"pool/e: both cego:zfs-cleaner:plan and cego:zfs-cleaner:keep are set on pool/e",
+		"pool/h: cego:zfs-cleaner:keep=1h for 1m: frequency cannot be bigger than age",
+		"pool/a/b: assigned to plan shortlived by configuration and longlived by property",
+	}
+	if len(conflicts) != len(expectedConflicts) {
+		t.Fatalf("resolvePlans() returned %d conflicts, expected %d: %v", len(conflicts), len(expectedConflicts), conflicts)
+	}
+	for i, conflict := range conflicts {
+		if conflict.Error() != expectedConflicts[i] {
+			t.Errorf("%d resolvePlans() returned conflict '%s', expected '%s'", i, conflict.Error(), expectedConflicts[i])
+		}
+	}
+
+	_, _, conflicts, _ = resolvePlans(zfsTestExecutor, testPropertiesConfig(), true)
+	if len(conflicts) != len(expectedConflicts)+1 || conflicts[len(conflicts)-1].Error() != "pool/g: unknown plan missing" {
+		t.Fatalf("resolvePlans() did not report unknown plan: %v", conflicts)
+	}
+}
+
+func TestProcessAllProperties(t *testing.T) {
+	zfsTestExecutor := &testExecutor{
+		properties: []byte("pool/c\tcego:zfs-cleaner:keep\tlatest 1\tlocal\n"),
+		getSnapshotListResult: []byte(`pool/c@snap1	1492989570
+pool/c@snap2	1492989571
+`),
+	}
+
+	config := &conf.Config{Properties: "cego:zfs-cleaner"}
+
+	lists, err := processAll(time.Unix(1492993419, 0), config, zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+
+	if len(lists) != 1 || lists[0].snapshots.String() != "[ pool/c@snap1:1492989570:false pool/c@snap2:1492989571:true ]" {
+		t.Fatalf("processAll() returned unexpected lists: %+v", lists)
+	}
+}
+
+func TestPlanCheckProperties(t *testing.T) {
+	zfsTestExecutor := &testExecutor{
+		properties:  testProperties,
+		filesystems: []byte("pool\npool/a\npool/a/b\npool/c\npool/d\npool/e\npool/f\npool/g\npool/h\n"),
+	}
+
+	var buf bytes.Buffer
+	stdout = &buf
+	defer func() { stdout = os.Stdout }()
+
+	err := planCheck(zfsTestExecutor, testPropertiesConfig(), false)
+	if err != nil {
+		t.Fatalf("planCheck() returned error: %s", err.Error())
+	}
+
+	expected := `Conflict: pool/e: both cego:zfs-cleaner:plan and cego:zfs-cleaner:keep are set on pool/e
+Conflict: pool/h: cego:zfs-cleaner:keep=1h for 1m: frequency cannot be bigger than age
+Conflict: pool/a/b: assigned to plan shortlived by configuration and longlived by property
+Conflict: pool/g: unknown plan missing
+No plan found for path: 'pool'
+No plan found for path: 'pool/e'
+No plan found for path: 'pool/g'
+No plan found for path: 'pool/h'
+`
+	if buf.String() != expected {
+		t.Fatalf("planCheck() printed:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cego/zfs-cleaner/zfs"
+)
+
+// showSummary enables the reclaimed space summary after real runs. Dry-runs
+// will always report the reclaimable space.
+var showSummary = false
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&showSummary, "summary", false, "Print the space reclaimed per dataset after cleaning")
+}
+
+// reclaimEstimate is the space zfs estimates destroying the snapshots of a
+// dataset will reclaim.
+type reclaimEstimate struct {
+	bytes     uint64
+	estimated bool
+}
+
+// estimateReclaim asks zfs how much space destroying the snapshots of all
+// batches of a dataset would reclaim. Space shared by several snapshots is
+// only reclaimed when all of them are destroyed, so zfs is asked about the
+// whole set at once. The set is only split if it would not fit in a single
+// argument. The estimate must happen before destroying anything. Errors are
+// reported, and will leave the dataset without an estimate.
+func estimateReclaim(batches []*destroyBatch) reclaimEstimate {
+	snapshots := zfs.SnapshotList{}
+	for _, batch := range batches {
+		// zfs cannot estimate destroying clones along with the
+		// snapshot.
+		if !batch.recursive {
+			snapshots = append(snapshots, batch.snapshots...)
+		}
+	}
+	if len(snapshots) == 0 {
+		return reclaimEstimate{}
+	}
+	zfsExecutor := batches[0].zfsExecutor
+	var estimate reclaimEstimate
+	for _, part := range snapshots.Batches(len(snapshots)) {
+		dataset := part[0].DatasetName()
+		names := make([]string, len(part))
+		for i, snapshot := range part {
+			names[i] = snapshot.SnapshotName()
+		}
+		reclaim, err := zfsExecutor.EstimateReclaim(dataset, names)
+		if err != nil {
+			reportError(dataset, err)
+			return reclaimEstimate{}
+		}
+		estimate.bytes += reclaim
+	}
+	estimate.estimated = true
+	return estimate
+}
+
+// reportReclaim reports the space reclaimable in each list, and the total.
+// estimates is indexed like lists, and batches holds the batches of each
+// list. After a real run, only datasets where every estimated snapshot was
+// destroyed are counted.
+func reportReclaim(lists []datasetList, batches [][]*destroyBatch, estimates []reclaimEstimate) {
+	verb := "reclaimed"
+	if dryrun {
+		verb = "reclaimable"
+	}
+	var total uint64
+	for i, list := range lists {
+		if !estimates[i].estimated || (!dryrun && !destroyedAll(batches[i])) {
+			continue
+		}
+		reclaim := estimates[i].bytes
+		total += reclaim
+		reportBytes(list.dataset, reclaim, "Space %s in %s: %s", verb, list.dataset, formatBytes(reclaim))
+	}
+	reportBytes("", total, "Space %s in total: %s", verb, formatBytes(total))
+}
+
+// destroyedAll returns true if every snapshot of the batches not destroying
+// clones was destroyed.
+func destroyedAll(batches []*destroyBatch) bool {
+	for _, batch := range batches {
+		if !batch.recursive && batch.destroyed < len(batch.snapshots) {
+			return false
+		}
+	}
+	return true
+}
+
+// reportBytes prints a message about a number of bytes in text mode, and
+// emits a reclaim record otherwise.
+func reportBytes(dataset string, bytes uint64, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if structuredOutput() {
+		emit(record{Action: actionReclaim, Dataset: dataset, Bytes: &bytes, Message: message})
+		return
+	}
+	fmt.Fprintf(stdout, "%s\n", message)
+}
+
+// formatBytes formats bytes using the same units accepted in the
+// configuration, keeping the exact number for reference.
+func formatBytes(bytes uint64) string {
+	const units = "KMGTP"
+	if bytes < 1024 {
+		return fmt.Sprintf("%d bytes", bytes)
+	}
+	value := float64(bytes) / 1024
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%c (%d bytes)", value, units[unit], bytes)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+)
+
+func TestReclaimSummary(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	output := []byte(`playground/fs1@snap1	1492989570
+playground/fs1@snap2	1492989571
+playground/fs1@snap3	1492989572
+playground/fs2@snap1	1492989573
+playground/fs2@snap2	1492989574
+`)
+	reclaim := map[string]uint64{
+		"playground/fs1@snap1": 1024,
+		"playground/fs1@snap2": 2048,
+		"playground/fs2@snap1": 100,
+	}
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{
+				Name:   "buh",
+				Paths:  []string{"playground/fs1", "playground/fs2"},
+				Latest: 1,
+			},
+		},
+	}
+
+	cases := []struct {
+		dryrun    bool
+		summary   bool
+		destroyed []string
+		expected  []string
+	}{
+		{false, false, []string{"playground/fs1@snap1", "playground/fs1@snap2", "playground/fs2@snap1"}, nil},
+		{true, false, nil, []string{
+			"Space reclaimable in playground/fs1: 3.0K (3072 bytes)",
+			"Space reclaimable in playground/fs2: 100 bytes",
+			"Space reclaimable in total: 3.1K (3172 bytes)",
+		}},
+		{false, true, []string{"playground/fs1@snap1", "playground/fs1@snap2", "playground/fs2@snap1"}, []string{
+			"Space reclaimed in playground/fs1: 3.0K (3072 bytes)",
+			"Space reclaimed in playground/fs2: 100 bytes",
+			"Space reclaimed in total: 3.1K (3172 bytes)",
+		}},
+	}
+
+	for i, c := range cases {
+		zfsTestExecutor := &testExecutor{
+			getSnapshotListResult: output,
+			reclaim:               reclaim,
+		}
+		dryrun = c.dryrun
+		showSummary = c.summary
+		withOutput(outputText, func(buffer *bytes.Buffer) {
+			err := cleanPlans(zfsTestExecutor, "test.conf", config)
+			if err != nil {
+				t.Fatalf("%d cleanPlans() returned error: %s", i, err.Error())
+			}
+
+			if !reflect.DeepEqual(zfsTestExecutor.destroyed, c.destroyed) {
+				t.Errorf("%d cleanPlans() destroyed %v, expected %v", i, zfsTestExecutor.destroyed, c.destroyed)
+			}
+
+			var lines []string
+			for _, line := range strings.Split(buffer.String(), "\n") {
+				if strings.HasPrefix(line, "Space ") {
+					lines = append(lines, line)
+				}
+			}
+			if !reflect.DeepEqual(lines, c.expected) {
+				t.Errorf("%d cleanPlans() printed %q, expected %q", i, lines, c.expected)
+			}
+		})
+	}
+	dryrun = false
+	showSummary = false
+}
+
+func TestReclaimSummaryJSONL(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570
+playground/fs1@snap2	1492989571
+`),
+		reclaim: map[string]uint64{"playground/fs1@snap1": 1024},
+	}
+	config := &conf.Config{
+		Plans: []conf.Plan{{Name: "buh", Paths: []string{"playground/fs1"}, Latest: 1}},
+	}
+
+	dryrun = true
+	defer func() { dryrun = false }()
+	withOutput(outputJSONL, func(buffer *bytes.Buffer) {
+		err := cleanPlans(zfsTestExecutor, "test.conf", config)
+		if err != nil {
+			t.Fatalf("cleanPlans() returned error: %s", err.Error())
+		}
+
+		expected := []string{
+			`{"action":"reclaim","dataset":"playground/fs1","message":"Space reclaimable in playground/fs1: 1.0K (1024 bytes)","bytes":1024}`,
+			`{"action":"reclaim","message":"Space reclaimable in total: 1.0K (1024 bytes)","bytes":1024}`,
+		}
+		for _, line := range expected {
+			if !strings.Contains(buffer.String(), line) {
+				t.Errorf("cleanPlans() did not output %s:\n%s", line, buffer.String())
+			}
+		}
+	})
+}
+
+func TestReclaimBatchSize(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570
+playground/fs1@snap2	1492989571
+playground/fs1@snap3	1492989572
+`),
+		reclaim: map[string]uint64{
+			"playground/fs1@snap1": 1024,
+			"playground/fs1@snap2": 1024,
+		},
+		reclaimShared: map[string]uint64{"playground/fs1@snap1,snap2": 2048},
+	}
+	config := &conf.Config{
+		Plans: []conf.Plan{{Name: "buh", Paths: []string{"playground/fs1"}, Latest: 1}},
+	}
+
+	// Space shared by both snapshots is only reclaimed when both are
+	// destroyed, even if they are destroyed one at a time.
+	dryrun = true
+	batchSize = 1
+	defer func() {
+		dryrun = false
+		batchSize = 100
+	}()
+	withOutput(outputText, func(buffer *bytes.Buffer) {
+		err := cleanPlans(zfsTestExecutor, "test.conf", config)
+		if err != nil {
+			t.Fatalf("cleanPlans() returned error: %s", err.Error())
+		}
+
+		expected := "Space reclaimable in playground/fs1: 4.0K (4096 bytes)"
+		if !strings.Contains(buffer.String(), expected) {
+			t.Errorf("cleanPlans() did not print '%s':\n%s", expected, buffer.String())
+		}
+	})
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := []struct {
+		bytes    uint64
+		expected string
+	}{
+		{0, "0 bytes"},
+		{1023, "1023 bytes"},
+		{1536, "1.5K (1536 bytes)"},
+		{5 * 1024 * 1024 * 1024, "5.0G (5368709120 bytes)"},
+		{3 << 60, "3072.0P (3458764513820540928 bytes)"},
+	}
+
+	for i, c := range cases {
+		if got := formatBytes(c.bytes); got != c.expected {
+			t.Errorf("%d formatBytes(%d) returned %s, expected %s", i, c.bytes, got, c.expected)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+
+	"github.com/cego/zfs-cleaner/conf"
+	"github.com/cego/zfs-cleaner/zfs"
+)
+
+// peerSnapshots is the snapshots present on a replication peer. It maps
+// peer dataset names to snapshot names (the part after @), which map to
+// GUIDs. Names listed without a dataset are found under the empty dataset
+// name.
+type peerSnapshots map[string]map[string]string
+
+// add records the snapshot name with guid in dataset.
+func (p peerSnapshots) add(dataset string, name string, guid string) {
+	if p[dataset] == nil {
+		p[dataset] = make(map[string]string)
+	}
+	p[dataset][name] = guid
+}
+
+// forDataset returns the snapshots of the peer dataset replicating the local
+// dataset, or nil if there is none. A peer dataset with the same name is
+// preferred. Otherwise the peer dataset sharing the longest trailing path
+// with dataset is used, "backup/a" will replicate "pool/a". If single is true,
+// dataset is the only dataset of the plan, and a peer with a single dataset
+// will always replicate it.
+func (p peerSnapshots) forDataset(dataset string, single bool) map[string]string {
+	if names, found := p[dataset]; found {
+		return names
+	}
+	local := strings.Split(dataset, "/")
+	best := 0
+	var names map[string]string
+	for peer, snapshots := range p {
+		if peer == "" {
+			continue
+		}
+		n := commonSuffix(local, strings.Split(peer, "/"))
+		switch {
+		case n > best:
+			best = n
+			names = snapshots
+		case n == best && n > 0:
+			// Ambiguous, we cannot tell which peer dataset is the
+			// right one.
+			names = nil
+		}
+	}
+	if best > 0 {
+		return names
+	}
+	if single && len(p) == 1 {
+		for _, snapshots := range p {
+			return snapshots
+		}
+	}
+	return nil
+}
+
+// commonSuffix returns the number of trailing elements equal in a and b.
+func commonSuffix(a []string, b []string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n
+}
+
+// parseSnapshotNames parses a list of snapshot names, one per line. Names can
+// be full snapshot names or just the part after the @. Empty lines are
+// ignored.
+func parseSnapshotNames(input []byte) peerSnapshots {
+	names := make(peerSnapshots)
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		dataset := ""
+		if i := strings.IndexRune(name, '@'); i >= 0 {
+			dataset = name[:i]
+			name = name[i+1:]
+		}
+		if name != "" {
+			names.add(dataset, name, "")
+		}
+	}
+	return names
+}
+
+// replicaSnapshots returns the snapshots present on the replication peer
+// described by replica. The GUID is only known for local datasets. For those
+// the dataset and all its descendants are included.
+func replicaSnapshots(zfsExecutor zfs.Executor, replica conf.Replica) (peerSnapshots, error) {
+	switch replica.Kind {
+	case conf.ReplicaFile:
+		content, err := ioutil.ReadFile(replica.Args[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read replica snapshots: %s", err.Error())
+		}
+		return parseSnapshotNames(content), nil
+	case conf.ReplicaCommand:
+		output, err := exec.Command(replica.Args[0], replica.Args[1:]...).Output()
+		if exitError, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("failed to get replica snapshots from '%s' error: %s", strings.Join(replica.Args, " "), exitError.Stderr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return parseSnapshotNames(output), nil
+	case conf.ReplicaDataset:
+		dataset := replica.Args[0]
+		list, err := zfsExecutor.ListSnapshots(dataset)
+		if err != nil {
+			return nil, err
+		}
+		names := make(peerSnapshots)
+		for _, snapshot := range list {
+			name := snapshot.DatasetName()
+			if name == dataset || strings.HasPrefix(name, dataset+"/") {
+				names.add(name, snapshot.SnapshotName(), snapshot.GUID)
+			}
+		}
+		return names, nil
+	}
+	return nil, fmt.Errorf("unknown replica kind '%s'", replica.Kind)
+}
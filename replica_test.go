@@ -0,0 +1,225 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+)
+
+func TestParseSnapshotNames(t *testing.T) {
+	names := parseSnapshotNames([]byte("snap1\n  pool/fs@snap2  \n\n@snap3\n"))
+	expected := peerSnapshots{
+		"":        {"snap1": "", "snap3": ""},
+		"pool/fs": {"snap2": ""},
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("parseSnapshotNames() returned %v, expected %v", names, expected)
+	}
+}
+
+func TestProcessAllReplica(t *testing.T) {
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570	0	1001
+playground/fs1@snap2	1492989572	0	1002
+playground/fs1@snap3	1492989573	0	1003
+playground/fs1@snap4	1492989574	0	1004
+playground/backup@snap1	1492989570	0	1001
+playground/backup@snap2	1492989572	0	1002
+`),
+	}
+
+	tmpfile, err := ioutil.TempFile("/dev/shm", "test.replica")
+	if err != nil {
+		t.Fatalf("Failed to create replica file: %s", err.Error())
+	}
+	defer os.Remove(tmpfile.Name())
+	_, _ = tmpfile.Write([]byte("snap1\nsnap3\n"))
+	tmpfile.Close()
+
+	cases := []struct {
+		replica  conf.Replica
+		expected string
+	}{
+		{conf.Replica{Kind: conf.ReplicaFile, Args: []string{tmpfile.Name()}}, "snap3"},
+		{conf.Replica{Kind: conf.ReplicaCommand, Args: []string{"echo", "playground/remote@snap1"}}, "snap1"},
+		{conf.Replica{Kind: conf.ReplicaDataset, Args: []string{"playground/backup"}}, "snap2"},
+	}
+
+	for i, c := range cases {
+		config := &conf.Config{
+			Plans: []conf.Plan{
+				{
+					Name:     "buh",
+					Paths:    []string{"playground/fs1"},
+					Latest:   1,
+					Replicas: []conf.Replica{c.replica},
+				},
+			},
+		}
+
+		lists, err := processAll(time.Unix(1492993419, 0), config, zfsTestExecutor)
+		if err != nil {
+			t.Fatalf("%d processAll() returned error: %s", i, err.Error())
+		}
+
+		for _, snapshot := range lists[0].snapshots {
+			if snapshot.SnapshotName() == "snap4" {
+				continue
+			}
+			if snapshot.Keep != (snapshot.SnapshotName() == c.expected) {
+				t.Fatalf("%d processAll() returned wrong keep for %s: %v", i, snapshot.Name, lists[0].snapshots)
+			}
+		}
+	}
+}
+
+func TestPeerSnapshotsForDataset(t *testing.T) {
+	peer := peerSnapshots{
+		"backup/a":        {"s1": ""},
+		"backup/b":        {"s2": ""},
+		"pool/c":          {"s3": ""},
+		"backup/x/data":   {"s4": ""},
+		"backup/y/data":   {"s5": ""},
+		"backup/z/y/data": {"s6": ""},
+	}
+
+	cases := []struct {
+		dataset  string
+		single   bool
+		expected map[string]string
+	}{
+		{"pool/a", false, map[string]string{"s1": ""}},
+		{"tank/b", false, map[string]string{"s2": ""}},
+		{"pool/c", false, map[string]string{"s3": ""}},
+		{"pool/x/data", false, map[string]string{"s4": ""}},
+		{"pool/y/data", false, nil},
+		{"pool/z/y/data", false, map[string]string{"s6": ""}},
+		{"pool/data", false, nil},
+		{"pool/d", true, nil},
+	}
+
+	for i, c := range cases {
+		names := peer.forDataset(c.dataset, c.single)
+		if !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("%d forDataset(%s) returned %v, expected %v", i, c.dataset, names, c.expected)
+		}
+	}
+
+	single := peerSnapshots{"remote/other": {"s1": ""}}
+	if names := single.forDataset("pool/a", true); !reflect.DeepEqual(names, map[string]string{"s1": ""}) {
+		t.Errorf("forDataset() did not use the only peer dataset for a single dataset, got %v", names)
+	}
+	if names := single.forDataset("pool/a", false); names != nil {
+		t.Errorf("forDataset() used an unrelated peer dataset, got %v", names)
+	}
+}
+
+func TestProcessAllReplicaPerDataset(t *testing.T) {
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`pool/a@s1	1492989570	0	1001
+pool/a@s2	1492989572	0	1002
+pool/a@s3	1492989574	0	1003
+pool/b@s1	1492989570	0	2001
+pool/b@s2	1492989572	0	2002
+pool/b@s3	1492989574	0	2003
+`),
+	}
+
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{
+				Name:     "buh",
+				Paths:    []string{"pool/a", "pool/b"},
+				Latest:   1,
+				Replicas: []conf.Replica{{Kind: conf.ReplicaCommand, Args: []string{"printf", "backup/a@s2\\nbackup/b@s1\\n"}}},
+			},
+		},
+	}
+
+	lists, err := processAll(time.Unix(1492993419, 0), config, zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+
+	// s3 is kept by latest, the rest by the peer.
+	expected := map[string]string{
+		"pool/a@s1": "destroy",
+		"pool/a@s2": "keep",
+		"pool/b@s1": "keep",
+		"pool/b@s2": "destroy",
+	}
+	for _, list := range lists {
+		for _, snapshot := range list.snapshots {
+			action, found := expected[snapshot.Name]
+			if found && snapshot.Keep != (action == "keep") {
+				t.Errorf("processAll() returned wrong keep for %s: %v", snapshot.Name, snapshot.Keep)
+			}
+		}
+	}
+}
+
+func TestCheckReplicas(t *testing.T) {
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`pool/a@s1	1492989570	0	1001
+pool/a@s2	1492989572	0	1002
+pool/b@s1	1492989570	0	2001
+pool/b@s2	1492989572	0	2002
+`),
+	}
+
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{
+				Name:     "buh",
+				Paths:    []string{"pool/a", "pool/b"},
+				Latest:   1,
+				Replicas: []conf.Replica{{Kind: conf.ReplicaCommand, Args: []string{"echo", "backup/a@s1"}}},
+			},
+		},
+	}
+
+	lists, err := processAll(time.Unix(1492993419, 0), config, zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+
+	if err := checkDataset(config, lists[0]); err != nil {
+		t.Errorf("checkDataset() refused %s with a common snapshot: %s", lists[0].dataset, err.Error())
+	}
+	if err := checkDataset(config, lists[1]); err == nil {
+		t.Errorf("checkDataset() did not refuse %s without a common snapshot", lists[1].dataset)
+	}
+}
+
+func TestProcessAllReplicaError(t *testing.T) {
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{
+				Name:     "buh",
+				Paths:    []string{"playground/fs1"},
+				Latest:   1,
+				Replicas: []conf.Replica{{Kind: conf.ReplicaCommand, Args: []string{"false"}}},
+			},
+		},
+	}
+
+	lists, err := processAll(time.Unix(1492993419, 0), config, &testExecutor{})
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+
+	if len(lists) != 1 || lists[0].err == nil || len(lists[0].snapshots) != 0 {
+		t.Fatalf("processAll() did not fail the dataset on failing replica command")
+	}
+
+	failFast = true
+	defer func() { failFast = false }()
+	_, err = processAll(time.Unix(1492993419, 0), config, &testExecutor{})
+	if err == nil {
+		t.Fatalf("processAll() did not err on failing replica command with fail-fast")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+	"github.com/cego/zfs-cleaner/zfs"
+)
+
+type (
+	// retryPolicy decides how operations failing with transient errors
+	// are retried.
+	retryPolicy struct {
+		attempts int
+		backoff  time.Duration
+		maxWait  time.Duration
+	}
+
+	// retryOutcome records a snapshot that needed more than one attempt,
+	// or was given up on.
+	retryOutcome struct {
+		snapshot string
+		attempts int
+		err      error
+	}
+)
+
+// sleep can be replaced when testing.
+var sleep = time.Sleep
+
+// newRetryPolicy returns the retry policy configured in config.
+func newRetryPolicy(config *conf.Config) retryPolicy {
+	attempts, backoff, maxWait := config.RetryPolicy()
+	return retryPolicy{
+		attempts: attempts,
+		backoff:  backoff,
+		maxWait:  maxWait,
+	}
+}
+
+// wait returns the wait before retry n, starting at 1. The wait is doubled
+// for every retry, but never longer than maxWait.
+func (r retryPolicy) wait(n int) time.Duration {
+	wait := r.backoff
+	for i := 1; i < n && wait < r.maxWait; i++ {
+		wait *= 2
+	}
+	if wait > r.maxWait {
+		return r.maxWait
+	}
+	return wait
+}
+
+// do calls f until it succeeds, fails with an error that cannot be retried,
+// or all attempts are used. The number of attempts made is returned. name is
+// used for telling the user about retries.
+func (r retryPolicy) do(name string, f func() error) (int, error) {
+	attempts := 1
+	err := f()
+	for ; err != nil && zfs.Retryable(err) && attempts < r.attempts; attempts++ {
+		wait := r.wait(attempts)
+		if verbose && !structuredOutput() {
+			fmt.Fprintf(stdout, "### Retrying %s in %s: %s\n", name, wait, err.Error())
+		}
+		sleep(wait)
+		err = f()
+	}
+	return attempts, err
+}
+
+// reportRetries lists the snapshots of batches destroyed after retrying,
+// and the snapshots given up on.
+func reportRetries(batches [][]*destroyBatch) {
+	for _, list := range batches {
+		for _, batch := range list {
+			for _, outcome := range batch.retries {
+				if outcome.err == nil {
+					info("Destroyed %s after %d attempts", outcome.snapshot, outcome.attempts)
+					continue
+				}
+				info("Gave up on %s after %d attempt(s): %s", outcome.snapshot, outcome.attempts, outcome.err.Error())
+			}
+		}
+	}
+}
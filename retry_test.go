@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+	"github.com/cego/zfs-cleaner/zfs"
+)
+
+func TestRetryPolicyWait(t *testing.T) {
+	r := retryPolicy{attempts: 5, backoff: time.Second, maxWait: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, e := range expected {
+		if wait := r.wait(i + 1); wait != e {
+			t.Errorf("wait(%d) returned %s, expected %s", i+1, wait, e)
+		}
+	}
+}
+
+func TestCleanPlansRetry(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	waits := []time.Duration{}
+	sleep = func(d time.Duration) {
+		waits = append(waits, d)
+	}
+	defer func() { sleep = time.Sleep }()
+
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570
+playground/fs1@snap2	1492989571
+playground/fs1@snap3	1492989572
+playground/fs1@snap4	1492989573
+`),
+		destroySnapshotsError: &zfs.CommandError{Op: "failed to destroy snapshots", Err: zfs.ErrBusy},
+		busy: map[string]int{
+			"playground/fs1@snap1": 2,
+			"playground/fs1@snap2": 5,
+		},
+	}
+	config := &conf.Config{
+		RetryAttempts: 3,
+		RetryBackoff:  time.Second,
+		RetryMaxWait:  time.Minute,
+		Plans:         []conf.Plan{{Name: "buh", Paths: []string{"playground/fs1"}, Latest: 1}},
+	}
+
+	withOutput(outputText, func(buffer *bytes.Buffer) {
+		err := cleanPlans(zfsTestExecutor, "test.conf", config)
+		if _, ok := err.(*partialFailure); !ok {
+			t.Fatalf("cleanPlans() returned %v, expected a partial failure", err)
+		}
+
+		// snap2 is given up on, but snap3 is still destroyed.
+		expected := []string{"playground/fs1@snap1", "playground/fs1@snap3"}
+		if !reflect.DeepEqual(zfsTestExecutor.destroyed, expected) {
+			t.Fatalf("cleanPlans() destroyed %v, expected %v", zfsTestExecutor.destroyed, expected)
+		}
+
+		expectedWaits := []time.Duration{time.Second, 2 * time.Second, time.Second, 2 * time.Second}
+		if !reflect.DeepEqual(waits, expectedWaits) {
+			t.Fatalf("cleanPlans() waited %v, expected %v", waits, expectedWaits)
+		}
+
+		for _, line := range []string{
+			"Destroyed playground/fs1@snap1 after 3 attempts",
+			"Gave up on playground/fs1@snap2 after 3 attempt(s): failed to destroy snapshot error: ",
+			"playground/fs1  buh   failed  2          1       gave up on 1 snapshot(s) after retrying",
+		} {
+			if !strings.Contains(buffer.String(), line) {
+				t.Errorf("cleanPlans() did not print '%s':\n%s", line, buffer.String())
+			}
+		}
+	})
+}
+
+func TestCleanPlansNoRetry(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	sleep = func(d time.Duration) {
+		t.Fatalf("sleep() called without retries configured")
+	}
+	defer func() { sleep = time.Sleep }()
+
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570
+playground/fs1@snap2	1492989571
+`),
+		busy: map[string]int{"playground/fs1@snap1": 1},
+	}
+	config := &conf.Config{
+		Plans: []conf.Plan{{Name: "buh", Paths: []string{"playground/fs1"}, Latest: 1}},
+	}
+
+	err := cleanPlans(zfsTestExecutor, "test.conf", config)
+	if _, ok := err.(*partialFailure); !ok {
+		t.Fatalf("cleanPlans() returned %v, expected a partial failure", err)
+	}
+
+	if len(zfsTestExecutor.destroyed) != 0 {
+		t.Fatalf("cleanPlans() destroyed %v", zfsTestExecutor.destroyed)
+	}
+}
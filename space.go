@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cego/zfs-cleaner/zfs"
+)
+
+// hasSpaceTarget returns true if the plan of list has a space target.
+func (l datasetList) hasSpaceTarget() bool {
+	return l.plan.TargetFreePercent > 0 || l.plan.MaxSnapshotUsage > 0
+}
+
+// pruneForSpace will stop keeping snapshots kept only by periods, oldest
+// first, in lists with a space target until the target is estimated to be
+// reached. max-snapshot-usage is applied to each dataset by itself, while
+// target-free applies to the pool, pruning the oldest snapshots of every list
+// with a target in the pool first. Space freed is estimated from the used
+// property of each snapshot destroyed. Errors are reported, and will leave
+// the lists as they were.
+func pruneForSpace(zfsExecutor zfs.Executor, lists []datasetList) {
+	datasets := []string{}
+	for _, list := range lists {
+		if list.plan.MaxSnapshotUsage > 0 {
+			datasets = append(datasets, list.dataset)
+		}
+	}
+	var usage map[string]zfs.Usage
+	if len(datasets) > 0 {
+		var err error
+		usage, err = zfsExecutor.GetUsage(datasets)
+		if err != nil {
+			reportError("", err)
+			usage = nil
+		}
+	}
+	for _, list := range lists {
+		if list.plan.MaxSnapshotUsage == 0 {
+			continue
+		}
+		u, found := usage[list.dataset]
+		if !found {
+			reportError(list.dataset, fmt.Errorf("no space usage for %s, max-snapshot-usage ignored", list.dataset))
+			continue
+		}
+		limit := list.plan.MaxSnapshotUsage + list.snapshots.DoomedUsage()
+		if u.UsedBySnapshots <= limit {
+			continue
+		}
+		need := u.UsedBySnapshots - limit
+		pruned, bytes := list.snapshots.Prune(need)
+		info("Destroying %d extra snapshot(s) in %s to reach space target, estimated %d of %d bytes freed", pruned, list.dataset, bytes, need)
+	}
+	// Space already expected to be freed in each pool, and the highest
+	// target-free of each pool along with the snapshots it may prune.
+	freed := make(map[string]uint64)
+	percent := make(map[string]int)
+	candidates := make(map[string]zfs.SnapshotList)
+	pools := []string{}
+	for _, list := range lists {
+		pool := zfs.PoolName(list.dataset)
+		freed[pool] += list.snapshots.DoomedUsage()
+		if list.plan.TargetFreePercent == 0 {
+			continue
+		}
+		if _, found := percent[pool]; !found {
+			pools = append(pools, pool)
+		}
+		if list.plan.TargetFreePercent > percent[pool] {
+			percent[pool] = list.plan.TargetFreePercent
+		}
+		candidates[pool] = append(candidates[pool], list.snapshots...)
+	}
+	for _, pool := range pools {
+		space, err := zfsExecutor.GetPoolSpace(pool)
+		if err != nil {
+			reportError("", err)
+			continue
+		}
+		target := space.Size / 100 * uint64(percent[pool])
+		free := space.Free + freed[pool]
+		if target <= free {
+			continue
+		}
+		need := target - free
+		// Snapshots of several datasets are pruned oldest first.
+		snapshots := candidates[pool]
+		sort.SliceStable(snapshots, func(i, j int) bool {
+			return snapshots[i].Creation.Before(snapshots[j].Creation)
+		})
+		pruned, bytes := snapshots.Prune(need)
+		info("Destroying %d extra snapshot(s) in pool %s to reach space target, estimated %d of %d bytes freed", pruned, pool, bytes, need)
+	}
+}
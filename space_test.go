@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+	"github.com/cego/zfs-cleaner/zfs"
+)
+
+func TestPruneForSpace(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	output := []byte(`playground/fs1@snap1	1492989570	0	1	60
+playground/fs1@snap2	1492989571	0	2	60
+playground/fs1@snap3	1492989572	1	3	60
+playground/fs1@snap4	1492989573	0	4	60
+playground/fs1@snap5	1492989574	0	5	60
+playground/fs2@snap1	1492989575	0	6	60
+playground/fs2@snap2	1492989576	0	7	60
+`)
+	keepAll := []conf.Period{{Frequency: 0, Age: 24 * time.Hour}}
+
+	cases := []struct {
+		plan     conf.Plan
+		usage    map[string]zfs.Usage
+		pools    map[string]zfs.PoolSpace
+		expected []string
+	}{
+		{
+			conf.Plan{Paths: []string{"playground/fs1", "playground/fs2"}, Latest: 1, Periods: keepAll},
+			nil,
+			nil,
+			[]string{"[ playground/fs1@snap1:1492989570:true playground/fs1@snap2:1492989571:true playground/fs1@snap3:1492989572:true playground/fs1@snap4:1492989573:true playground/fs1@snap5:1492989574:true ]", "[ playground/fs2@snap1:1492989575:true playground/fs2@snap2:1492989576:true ]"},
+		},
+		{
+			// Needs 100 bytes. Pruning fs1 is enough, fs2 is left alone.
+			conf.Plan{Paths: []string{"playground/fs1", "playground/fs2"}, Latest: 1, Periods: keepAll, TargetFreePercent: 20},
+			nil,
+			map[string]zfs.PoolSpace{"playground": {Free: 100, Size: 1000}},
+			[]string{"[ playground/fs1@snap1:1492989570:false playground/fs1@snap2:1492989571:false playground/fs1@snap3:1492989572:true playground/fs1@snap4:1492989573:true playground/fs1@snap5:1492989574:true ]", "[ playground/fs2@snap1:1492989575:true playground/fs2@snap2:1492989576:true ]"},
+		},
+		{
+			// Held and latest snapshots are never pruned.
+			conf.Plan{Paths: []string{"playground/fs1"}, Latest: 1, Periods: keepAll, TargetFreePercent: 90},
+			nil,
+			map[string]zfs.PoolSpace{"playground": {Free: 100, Size: 1000}},
+			[]string{"[ playground/fs1@snap1:1492989570:false playground/fs1@snap2:1492989571:false playground/fs1@snap3:1492989572:true playground/fs1@snap4:1492989573:false playground/fs1@snap5:1492989574:true ]"},
+		},
+		{
+			conf.Plan{Paths: []string{"playground/fs1"}, Latest: 1, Periods: keepAll, MaxSnapshotUsage: 250},
+			map[string]zfs.Usage{"playground/fs1": {Used: 1000, UsedBySnapshots: 300}},
+			nil,
+			[]string{"[ playground/fs1@snap1:1492989570:false playground/fs1@snap2:1492989571:true playground/fs1@snap3:1492989572:true playground/fs1@snap4:1492989573:true playground/fs1@snap5:1492989574:true ]"},
+		},
+		{
+			// Errors leave the list alone.
+			conf.Plan{Paths: []string{"playground/fs1"}, Latest: 1, Periods: keepAll, TargetFreePercent: 90},
+			nil,
+			map[string]zfs.PoolSpace{},
+			[]string{"[ playground/fs1@snap1:1492989570:true playground/fs1@snap2:1492989571:true playground/fs1@snap3:1492989572:true playground/fs1@snap4:1492989573:true playground/fs1@snap5:1492989574:true ]"},
+		},
+	}
+
+	for i, c := range cases {
+		zfsTestExecutor := &testExecutor{
+			getSnapshotListResult: output,
+			usage:                 c.usage,
+			pools:                 c.pools,
+		}
+		c.plan.Name = "buh"
+		lists, err := processAll(now, &conf.Config{Plans: []conf.Plan{c.plan}}, zfsTestExecutor)
+		if err != nil {
+			t.Fatalf("%d processAll() returned error: %s", i, err.Error())
+		}
+
+		for j, list := range lists {
+			if list.snapshots.String() != c.expected[j] {
+				t.Errorf("%d processAll() returned %s, expected %s", i, list.snapshots.String(), c.expected[j])
+			}
+		}
+	}
+}
+
+func TestPruneForSpacePool(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	zfsTestExecutor := &testExecutor{
+		getSnapshotListResult: []byte(`playground/fs3@snap1	1492989570	0	1	60
+playground/fs2@snap1	1492989571	0	2	60
+playground/fs1@snap1	1492989572	0	3	60
+playground/fs2@snap2	1492989573	0	4	60
+playground/fs1@snap2	1492989574	0	5	60
+playground/fs2@snap3	1492989575	0	6	60
+playground/fs1@snap3	1492989576	0	7	60
+playground/fs3@snap2	1492989577	0	8	60
+`),
+		pools: map[string]zfs.PoolSpace{"playground": {Free: 80, Size: 1000}},
+	}
+	keepAll := []conf.Period{{Frequency: 0, Age: 24 * time.Hour}}
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{Name: "a", Paths: []string{"playground/fs1"}, Latest: 1, Periods: keepAll, TargetFreePercent: 15},
+			{Name: "b", Paths: []string{"playground/fs2"}, Latest: 1, Periods: keepAll, TargetFreePercent: 20},
+			{Name: "c", Paths: []string{"playground/fs3"}, Latest: 1, Periods: keepAll},
+		},
+	}
+
+	// Needs 120 bytes for the highest target of the pool, 20%. The oldest
+	// snapshots of the datasets with a target are pruned first, the
+	// dataset without a target is left alone.
+	lists, err := processAll(now, config, zfsTestExecutor)
+	if err != nil {
+		t.Fatalf("processAll() returned error: %s", err.Error())
+	}
+	expected := []string{
+		"[ playground/fs1@snap1:1492989572:false playground/fs1@snap2:1492989574:true playground/fs1@snap3:1492989576:true ]",
+		"[ playground/fs2@snap1:1492989571:false playground/fs2@snap2:1492989573:true playground/fs2@snap3:1492989575:true ]",
+		"[ playground/fs3@snap1:1492989570:true playground/fs3@snap2:1492989577:true ]",
+	}
+	if len(lists) != len(expected) {
+		t.Fatalf("processAll() returned %d lists, expected %d", len(lists), len(expected))
+	}
+	for i, list := range lists {
+		if list.snapshots.String() != expected[i] {
+			t.Errorf("%d processAll() returned %s, expected %s", i, list.snapshots.String(), expected[i])
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+)
+
+// exitPartialFailure is the exit status used when some datasets failed, but
+// the rest were cleaned.
+const exitPartialFailure = 2
+
+const (
+	statusOK     = "ok"
+	statusFailed = "failed"
+)
+
+// partialFailure is returned when one or more datasets failed while the run
+// continued with the rest.
+type partialFailure struct {
+	failed int
+	total  int
+}
+
+// Error implements error.
+func (e *partialFailure) Error() string {
+	return fmt.Sprintf("%d of %d dataset(s) failed, the rest were cleaned", e.failed, e.total)
+}
+
+// summarize reports the outcome of every dataset in lists if any of them
+// failed. destroyed, failed and failures are indexed like
+// lists. A partialFailure is returned if any dataset failed.
+func summarize(lists []datasetList, destroyed []int, failed []int, failures []error) error {
+	count := 0
+	for _, failure := range failures {
+		if failure != nil {
+			count++
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	if !structuredOutput() {
+		fmt.Fprintf(tw, "DATASET\tPLAN\tSTATUS\tDESTROYED\tFAILED\tERROR\n")
+	}
+	for i, list := range lists {
+		status := statusOK
+		message := ""
+		if failures[i] != nil {
+			status = statusFailed
+			message = failures[i].Error()
+		}
+		if structuredOutput() {
+			emit(record{
+				Action:    actionSummary,
+				Plan:      list.planName(),
+				Dataset:   list.dataset,
+				Status:    status,
+				Destroyed: &destroyed[i],
+				Failed:    &failed[i],
+				Error:     message,
+			})
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n", list.dataset, list.planName(), status, destroyed[i], failed[i], message)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return &partialFailure{failed: count, total: len(lists)}
+}
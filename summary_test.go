@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cego/zfs-cleaner/conf"
+)
+
+func TestCleanPlansIsolation(t *testing.T) {
+	now = time.Unix(1492993419, 0)
+	config := &conf.Config{
+		Plans: []conf.Plan{
+			{
+				Name:   "buh",
+				Paths:  []string{"playground/fs1", "playground/fs2"},
+				Latest: 1,
+			},
+		},
+	}
+
+	cases := []struct {
+		failFast  bool
+		destroyed []string
+	}{
+		{false, []string{"playground/fs2@snap1", "playground/fs2@snap2"}},
+		{true, nil},
+	}
+
+	for i, c := range cases {
+		zfsTestExecutor := &testExecutor{
+			getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570
+playground/fs1@snap2	1492989571
+playground/fs1@snap3	1492989572
+playground/fs2@snap1	1492989573
+playground/fs2@snap2	1492989574
+playground/fs2@snap3	1492989575
+`),
+			destroySnapshotsError: fmt.Errorf("dataset is busy"),
+			destroySnapshotErrors: map[string]error{"playground/fs1@snap1": fmt.Errorf("dataset is busy")},
+		}
+
+		failFast = c.failFast
+		withOutput(outputText, func(buffer *bytes.Buffer) {
+			err := cleanPlans(zfsTestExecutor, "test.conf", config)
+			if err == nil {
+				t.Fatalf("%d cleanPlans() did not return error", i)
+			}
+
+			_, partial := err.(*partialFailure)
+			if partial == c.failFast {
+				t.Fatalf("%d cleanPlans() returned %T: %s", i, err, err.Error())
+			}
+
+			if !reflect.DeepEqual(zfsTestExecutor.destroyed, c.destroyed) {
+				t.Fatalf("%d cleanPlans() destroyed %v, expected %v", i, zfsTestExecutor.destroyed, c.destroyed)
+			}
+
+			if c.failFast {
+				if strings.Contains(buffer.String(), "STATUS") {
+					t.Fatalf("%d cleanPlans() printed summary with fail-fast:\n%s", i, buffer.String())
+				}
+				return
+			}
+
+			lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+			if len(lines) != 3 {
+				t.Fatalf("%d cleanPlans() printed %d lines, expected 3:\n%s", i, len(lines), buffer.String())
+			}
+
+			if !strings.HasPrefix(lines[1], "playground/fs1  buh   failed  0          1       dataset is busy") {
+				t.Errorf("%d cleanPlans() printed wrong summary for fs1: '%s'", i, lines[1])
+			}
+
+			if strings.TrimSpace(lines[2]) != "playground/fs2  buh   ok      2          0" {
+				t.Errorf("%d cleanPlans() printed wrong summary for fs2: '%s'", i, lines[2])
+			}
+		})
+	}
+	failFast = false
+}
+
+func TestSummarize(t *testing.T) {
+	lists := []datasetList{
+		{plan: conf.Plan{Name: "buh"}, dataset: "playground/fs1"},
+		{plan: conf.Plan{Name: "buh"}, dataset: "playground/fs2"},
+	}
+
+	withOutput(outputJSONL, func(buffer *bytes.Buffer) {
+		err := summarize(lists, []int{0, 0}, []int{0, 0}, []error{nil, nil})
+		if err != nil {
+			t.Fatalf("summarize() returned error without failures: %s", err.Error())
+		}
+
+		if buffer.Len() != 0 {
+			t.Fatalf("summarize() printed summary without failures: %s", buffer.String())
+		}
+
+		err = summarize(lists, []int{0, 3}, []int{1, 0}, []error{fmt.Errorf("busy"), nil})
+		if err == nil || err.Error() != "1 of 2 dataset(s) failed, the rest were cleaned" {
+			t.Fatalf("summarize() returned wrong error: %v", err)
+		}
+
+		expected := `{"action":"summary","plan":"buh","dataset":"playground/fs1","error":"busy","status":"failed","destroyed":0,"failed":1}
+{"action":"summary","plan":"buh","dataset":"playground/fs2","status":"ok","destroyed":3,"failed":0}
+`
+		if buffer.String() != expected {
+			t.Fatalf("summarize() emitted:\n%s\nexpected:\n%s", buffer.String(), expected)
+		}
+	})
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+
 	"github.com/cego/zfs-cleaner/zfs"
 )
 
@@ -10,42 +13,237 @@ type todo interface {
 }
 
 var (
-	_ todo = (*destroySnapshot)(nil)
+	_ todo = (*destroyBatch)(nil)
+	_ todo = (*decision)(nil)
 	_ todo = (*noop)(nil)
+	_ todo = (*notice)(nil)
 )
 
-type destroySnapshot struct {
-	comment     string
+type destroyBatch struct {
 	zfsExecutor zfs.Executor
-	snapshot    *zfs.Snapshot
+	plan        string
+	snapshots   zfs.SnapshotList
+
+	// destroyed and failed counts the outcome of Do.
+	destroyed int
+	failed    int
+
+	// retry is used for destroying single snapshots. retries records
+	// the snapshots retried or given up on.
+	retry   retryPolicy
+	retries []retryOutcome
+
+	// recursive destroys the snapshots along with their clones. Such
+	// batches must have a single snapshot.
+	recursive bool
+}
+
+type decision struct {
+	list      datasetList
+	snapshot  *zfs.Snapshot
+	unmanaged bool
 }
 
 type noop struct {
 	comment string
 }
 
-func newDestroy(zfsExecutor zfs.Executor, snapshot *zfs.Snapshot) todo {
-	return &destroySnapshot{
-		comment:     fmt.Sprintf("Destroying %s (Age %s)", snapshot.Name, now.Sub(snapshot.Creation)),
+type notice struct {
+	message string
+}
+
+// newDestroyBatch will destroy all snapshots in a single zfs command. All
+// snapshots must belong to the same dataset. The batch will not print
+// per-snapshot comments, callers should add those using newDecision.
+func newDestroyBatch(zfsExecutor zfs.Executor, plan string, snapshots zfs.SnapshotList) *destroyBatch {
+	return &destroyBatch{
 		zfsExecutor: zfsExecutor,
-		snapshot:    snapshot,
+		plan:        plan,
+		snapshots:   snapshots,
+		retry:       retryPolicy{attempts: 1},
 	}
 }
 
-func (d *destroySnapshot) Do() error {
-	if verbose {
-		fmt.Fprintf(stdout, "### %s\n", d.comment)
+func (d *destroyBatch) Do() error {
+	dataset := d.snapshots[0].DatasetName()
+	names := make([]string, len(d.snapshots))
+	for i, snapshot := range d.snapshots {
+		names[i] = snapshot.SnapshotName()
 	}
-	if verbose || dryrun {
-		fmt.Fprintf(stdout, "# Running 'zfs destroy %s'\n", d.snapshot.Name)
+	command := fmt.Sprintf("zfs destroy %s@%s", dataset, strings.Join(names, ","))
+	if d.recursive {
+		command = fmt.Sprintf("zfs destroy -R %s@%s", dataset, strings.Join(names, ","))
 	}
-	if !dryrun {
-		output, err := d.zfsExecutor.DestroySnapshot(d.snapshot.Name)
-		if err != nil {
+	if !structuredOutput() && (verbose || dryrun) {
+		fmt.Fprintf(stdout, "# Running '%s'\n", command)
+	}
+	if dryrun {
+		d.emit(actionWouldDestroy, command, nil)
+		return nil
+	}
+	if len(d.snapshots) == 1 || d.recursive {
+		return d.destroyEach(false)
+	}
+	output, err := d.zfsExecutor.DestroySnapshots(dataset, names)
+	if err == nil {
+		d.destroyed += len(d.snapshots)
+		d.emit(actionDestroyed, command, nil)
+		d.print(output)
+		return nil
+	}
+	// Fall back to destroying one snapshot at a time. This will tell us
+	// exactly which snapshot is causing trouble.
+	reportError(dataset, fmt.Errorf("%s\nRetrying snapshots one at a time", err.Error()))
+	return d.destroyEach(true)
+}
+
+// destroyEach destroys the snapshots of the batch one at a time. Snapshots
+// already gone are skipped, and snapshots with clones are kept with a
+// warning. Transient errors are retried according to the retry policy, and
+// the snapshot is skipped if it keeps failing. Other errors stop the batch.
+// If announce is true, each command is printed when verbose.
+func (d *destroyBatch) destroyEach(announce bool) error {
+	for _, snapshot := range d.snapshots {
+		attempts, err := d.destroyOne(snapshot, announce)
+		if attempts > 1 || zfs.Retryable(err) {
+			d.retries = append(d.retries, retryOutcome{
+				snapshot: snapshot.Name,
+				attempts: attempts,
+				err:      err,
+			})
+		}
+		switch {
+		case err == nil:
+			d.destroyed++
+		case errors.Is(err, zfs.ErrDatasetNotFound):
+			// Someone beat us to it.
+			reportError(snapshot.DatasetName(), fmt.Errorf("%s no longer exists, skipping", snapshot.Name))
+		case errors.Is(err, zfs.ErrHasClones):
+			d.failed++
+			reportError(snapshot.DatasetName(), fmt.Errorf("keeping %s, it has dependent clones", snapshot.Name))
+		case zfs.Retryable(err):
+			d.failed++
+			reportError(snapshot.DatasetName(), fmt.Errorf("giving up on %s after %d attempt(s): %s", snapshot.Name, attempts, err.Error()))
+		default:
+			d.failed++
 			return err
 		}
+	}
+	return nil
+}
+
+// abandoned returns the number of snapshots given up on after retrying.
+func (d *destroyBatch) abandoned() int {
+	count := 0
+	for _, outcome := range d.retries {
+		if outcome.err != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// destroyOne destroys a single snapshot of the batch, retrying transient
+// errors. The number of attempts made is returned.
+func (d *destroyBatch) destroyOne(snapshot *zfs.Snapshot, announce bool) (int, error) {
+	command := "zfs destroy " + snapshot.Name
+	destroy := d.zfsExecutor.DestroySnapshot
+	if d.recursive {
+		command = "zfs destroy -R " + snapshot.Name
+		destroy = d.zfsExecutor.DestroySnapshotRecursive
+	}
+	if announce && verbose && !structuredOutput() {
+		fmt.Fprintf(stdout, "# Running '%s'\n", command)
+	}
+	var output []byte
+	attempts, err := d.retry.do(snapshot.Name, func() error {
+		var err error
+		output, err = destroy(snapshot.Name)
+		return err
+	})
+	if structuredOutput() {
+		r := snapshotRecord(actionDestroyed, d.plan, snapshot)
+		r.Command = command
+		if err != nil {
+			r.Action = actionDestroyFailed
+			r.Error = err.Error()
+		}
+		emit(r)
+		return attempts, err
+	}
+	if err == nil {
+		d.print(output)
+	}
+	return attempts, err
+}
+
+// emit will emit a record with action for every snapshot in the batch.
+func (d *destroyBatch) emit(action string, command string, err error) {
+	if !structuredOutput() {
+		return
+	}
+	for _, snapshot := range d.snapshots {
+		r := snapshotRecord(action, d.plan, snapshot)
+		r.Command = command
+		if err != nil {
+			r.Error = err.Error()
+		}
+		emit(r)
+	}
+}
+
+// print will print output from zfs in text mode.
+func (d *destroyBatch) print(output []byte) {
+	if !structuredOutput() {
 		fmt.Fprintf(stdout, "%s", string(output))
 	}
+}
+
+// newDecision will report the decision to keep or destroy snapshot.
+func newDecision(list datasetList, snapshot *zfs.Snapshot) todo {
+	return &decision{
+		list:     list,
+		snapshot: snapshot,
+	}
+}
+
+// newUnmanaged will report that snapshot is not managed by the plan of list.
+func newUnmanaged(list datasetList, snapshot *zfs.Snapshot) todo {
+	return &decision{
+		list:      list,
+		snapshot:  snapshot,
+		unmanaged: true,
+	}
+}
+
+func (d *decision) Do() error {
+	action := actionDestroy
+	if d.unmanaged {
+		action = actionUnmanaged
+	} else if d.snapshot.Keep {
+		action = actionKeep
+	}
+	if structuredOutput() {
+		emit(listRecord(action, d.list, d.snapshot))
+		return nil
+	}
+	if !verbose {
+		return nil
+	}
+	if d.unmanaged {
+		fmt.Fprintf(stdout, "### Unmanaged %s (Age %s)\n", d.snapshot.Name, now.Sub(d.snapshot.Creation))
+	} else if d.snapshot.Keep && len(d.list.merged) > 0 {
+		// Tell which plan kept the snapshot.
+		reasons := make([]string, len(d.snapshot.Reasons))
+		for i, reason := range d.snapshot.Reasons {
+			reasons[i] = reason.String()
+		}
+		fmt.Fprintf(stdout, "### Keep %s (Age %s): %s\n", d.snapshot.Name, now.Sub(d.snapshot.Creation), strings.Join(reasons, "; "))
+	} else if d.snapshot.Keep {
+		fmt.Fprintf(stdout, "### Keep %s (Age %s)\n", d.snapshot.Name, now.Sub(d.snapshot.Creation))
+	} else {
+		fmt.Fprintf(stdout, "### Destroying %s (Age %s)\n", d.snapshot.Name, now.Sub(d.snapshot.Creation))
+	}
 	return nil
 }
 
@@ -56,8 +254,21 @@ func newComment(format string, args ...interface{}) todo {
 }
 
 func (d *noop) Do() error {
-	if verbose {
+	if verbose && !structuredOutput() {
 		fmt.Fprintf(stdout, "### %s\n", d.comment)
 	}
 	return nil
 }
+
+// newNotice will tell the user about something worth knowing, even when not
+// verbose.
+func newNotice(format string, args ...interface{}) todo {
+	return &notice{
+		message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (n *notice) Do() error {
+	info("%s", n.message)
+	return nil
+}
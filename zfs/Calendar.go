@@ -0,0 +1,98 @@
+package zfs
+
+import (
+	"fmt"
+	"time"
+)
+
+// CalendarUnit is a calendar based unit for keeping snapshots.
+type CalendarUnit string
+
+const (
+	// Hourly buckets start at the top of every hour.
+	Hourly = CalendarUnit("hourly")
+
+	// Daily buckets start at local midnight.
+	Daily = CalendarUnit("daily")
+
+	// Weekly buckets start at local midnight on Monday (ISO weeks).
+	Weekly = CalendarUnit("weekly")
+
+	// Monthly buckets start at local midnight on the first of the month.
+	Monthly = CalendarUnit("monthly")
+
+	// Yearly buckets start at local midnight on January 1st.
+	Yearly = CalendarUnit("yearly")
+)
+
+// CalendarUnits is all known calendar units.
+var CalendarUnits = []CalendarUnit{Hourly, Daily, Weekly, Monthly, Yearly}
+
+// BucketStart returns the start of the bucket offset buckets before the bucket
+// containing t. Buckets are calculated in loc.
+func (u CalendarUnit) BucketStart(t time.Time, loc *time.Location, offset int) time.Time {
+	t = t.In(loc)
+	year, month, day := t.Date()
+
+	switch u {
+	case Hourly:
+		// Hours are counted in absolute time. Wall clock hours are
+		// skipped or repeated when daylight saving time changes.
+		top := t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+		return top.Add(-time.Duration(offset) * time.Hour)
+	case Daily:
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, loc)
+	case Weekly:
+		// time.Weekday starts at Sunday, ISO weeks at Monday.
+		monday := day - (int(t.Weekday())+6)%7
+		return time.Date(year, month, monday-7*offset, 0, 0, 0, 0, loc)
+	case Monthly:
+		return time.Date(year, month-time.Month(offset), 1, 0, 0, 0, 0, loc)
+	case Yearly:
+		return time.Date(year-offset, time.January, 1, 0, 0, 0, 0, loc)
+	}
+
+	panic(fmt.Sprintf("unknown calendar unit '%s'", u))
+}
+
+// bucketFormat returns a layout suitable for naming a bucket of u.
+func (u CalendarUnit) bucketFormat() string {
+	switch u {
+	case Hourly:
+		return "2006-01-02 15:00 MST"
+	case Monthly:
+		return "2006-01"
+	case Yearly:
+		return "2006"
+	}
+
+	return "2006-01-02"
+}
+
+// KeepCalendar will keep the first snapshot in each of the count latest
+// calendar buckets of unit, including the bucket containing now. The bucket
+// containing now ends at now, inclusive, so a snapshot taken exactly at now
+// fills it. Buckets are calculated in loc. rule is recorded as the reason for
+// keeping.
+func (l SnapshotList) KeepCalendar(now time.Time, unit CalendarUnit, count int, loc *time.Location, rule string) {
+	end := now.Add(time.Nanosecond)
+	for i := 0; i < count; i++ {
+		start := unit.BucketStart(now, loc, i)
+
+		s := l.Next(start)
+		if s != nil && s.Creation.Before(end) {
+			bucket := start.Format(unit.bucketFormat())
+			if unit == Weekly {
+				year, week := start.ISOWeek()
+				bucket = fmt.Sprintf("%d-W%02d", year, week)
+			}
+
+			s.keep(Reason{
+				Kind:   ReasonCalendar,
+				Detail: fmt.Sprintf("%s, bucket %s", rule, bucket),
+			})
+		}
+
+		end = start
+	}
+}
@@ -0,0 +1,141 @@
+package zfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketStart(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Skipf("Time zone data not available: %s", err.Error())
+	}
+
+	// Thursday January 2nd 2020, 13:37 local time.
+	now := time.Date(2020, time.January, 2, 13, 37, 0, 0, loc)
+
+	cases := []struct {
+		unit     CalendarUnit
+		offset   int
+		expected time.Time
+	}{
+		{Hourly, 0, time.Date(2020, time.January, 2, 13, 0, 0, 0, loc)},
+		{Hourly, 14, time.Date(2020, time.January, 1, 23, 0, 0, 0, loc)},
+		{Daily, 0, time.Date(2020, time.January, 2, 0, 0, 0, 0, loc)},
+		{Daily, 2, time.Date(2019, time.December, 31, 0, 0, 0, 0, loc)},
+		{Weekly, 0, time.Date(2019, time.December, 30, 0, 0, 0, 0, loc)},
+		{Weekly, 1, time.Date(2019, time.December, 23, 0, 0, 0, 0, loc)},
+		{Monthly, 0, time.Date(2020, time.January, 1, 0, 0, 0, 0, loc)},
+		{Monthly, 13, time.Date(2018, time.December, 1, 0, 0, 0, 0, loc)},
+		{Yearly, 0, time.Date(2020, time.January, 1, 0, 0, 0, 0, loc)},
+		{Yearly, 5, time.Date(2015, time.January, 1, 0, 0, 0, 0, loc)},
+	}
+
+	for i, c := range cases {
+		start := c.unit.BucketStart(now, loc, c.offset)
+		if !start.Equal(c.expected) {
+			t.Fatalf("%d BucketStart() returned %s, expected %s", i, start, c.expected)
+		}
+	}
+
+	// A Sunday must belong to the week starting the Monday before.
+	sunday := time.Date(2020, time.January, 5, 23, 0, 0, 0, loc)
+	start := Weekly.BucketStart(sunday, loc, 0)
+	if !start.Equal(time.Date(2019, time.December, 30, 0, 0, 0, 0, loc)) {
+		t.Fatalf("BucketStart() returned %s for Sunday", start)
+	}
+}
+
+func TestKeepCalendar(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	at := func(d int, hour int) *Snapshot {
+		return &Snapshot{
+			Name:     "fs@snap",
+			Creation: time.Date(2020, time.March, d, hour, 0, 0, 0, loc),
+		}
+	}
+
+	l := SnapshotList{
+		at(1, 0),
+		at(1, 12),
+		// 01:00 local is still the 2nd in UTC+2, but the 1st in UTC.
+		at(2, 1),
+		at(2, 12),
+		at(3, 12),
+		at(4, 6),
+		at(4, 18),
+	}
+
+	now := time.Date(2020, time.March, 4, 20, 0, 0, 0, loc)
+
+	l.KeepCalendar(now, Daily, 3, loc, "keep daily 3")
+	testKeep(0, t, l, []bool{false, false, true, false, true, true, false})
+
+	if l[2].Reasons[0].Detail != "keep daily 3, bucket 2020-03-02" {
+		t.Fatalf("KeepCalendar() recorded wrong reason: %s", l[2].Reasons[0])
+	}
+
+	l.ResetSieve()
+	l.KeepCalendar(now, Daily, 3, time.UTC, "keep daily 3")
+	testKeep(1, t, l, []bool{false, false, false, true, true, true, false})
+
+	l.ResetSieve()
+	l.KeepCalendar(now, Monthly, 12, loc, "keep monthly 12")
+	testKeep(2, t, l, []bool{true, false, false, false, false, false, false})
+}
+
+func TestKeepCalendarAtAnchor(t *testing.T) {
+	now := time.Date(2020, time.March, 4, 0, 0, 0, 0, time.UTC)
+	l := SnapshotList{
+		{Name: "fs@a", Creation: now.Add(-36 * time.Hour)},
+		{Name: "fs@b", Creation: now},
+	}
+
+	l.KeepCalendar(now, Daily, 1, time.UTC, "keep daily 1")
+	testKeep(0, t, l, []bool{false, true})
+
+	l.ResetSieve()
+	l.KeepCalendar(now, Hourly, 1, time.UTC, "keep hourly 1")
+	testKeep(1, t, l, []bool{false, true})
+}
+
+func TestKeepCalendarDST(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Skipf("Time zone data not available: %s", err.Error())
+	}
+
+	// Clocks went back from 03:00 CEST to 02:00 CET on October 25th 2020,
+	// 01:00 UTC. 02:30 happened twice.
+	at := func(hour int, minute int) *Snapshot {
+		return &Snapshot{
+			Name:     "fs@snap",
+			Creation: time.Date(2020, time.October, 25, hour, minute, 0, 0, time.UTC),
+		}
+	}
+
+	l := SnapshotList{
+		at(0, 30), // 02:30 CEST
+		at(1, 30), // 02:30 CET
+		at(2, 5),  // 03:05 CET
+	}
+
+	now := time.Date(2020, time.October, 25, 2, 10, 0, 0, time.UTC)
+	l.KeepCalendar(now, Hourly, 3, loc, "keep hourly 3")
+	testKeep(0, t, l, []bool{true, true, true})
+
+	if l[0].Reasons[0].Detail == l[1].Reasons[0].Detail {
+		t.Fatalf("KeepCalendar() used the same bucket twice: %s", l[0].Reasons[0].Detail)
+	}
+
+	// Clocks went forward from 02:00 CET to 03:00 CEST on March 29th 2020,
+	// 01:00 UTC. The buckets must still be an hour apart.
+	now = time.Date(2020, time.March, 29, 1, 10, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		start := Hourly.BucketStart(now, loc, i)
+		expected := time.Date(2020, time.March, 29, 1-i, 0, 0, 0, time.UTC)
+		if !start.Equal(expected) {
+			t.Fatalf("%d BucketStart() returned %s, expected %s", i, start.UTC(), expected)
+		}
+	}
+}
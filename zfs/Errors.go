@@ -0,0 +1,102 @@
+package zfs
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var (
+	// ErrDatasetNotFound is returned when a dataset, snapshot or pool does
+	// not exist.
+	ErrDatasetNotFound = errors.New("dataset does not exist")
+
+	// ErrHasClones is returned when destroying a snapshot with dependent
+	// clones.
+	ErrHasClones = errors.New("snapshot has dependent clones")
+
+	// ErrBusy is returned when a dataset is busy.
+	ErrBusy = errors.New("dataset is busy")
+
+	// ErrPermissionDenied is returned when zfs refuses the operation for
+	// lack of permissions.
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// stderrErrors maps messages printed by zfs and zpool to errors. The first
+// match wins.
+var stderrErrors = []struct {
+	message string
+	err     error
+}{
+	{"dataset does not exist", ErrDatasetNotFound},
+	{"could not find any snapshots to destroy", ErrDatasetNotFound},
+	{"no such pool", ErrDatasetNotFound},
+	{"has dependent clones", ErrHasClones},
+	{"dataset is busy", ErrBusy},
+	{"pool or dataset is busy", ErrBusy},
+	{"permission denied", ErrPermissionDenied},
+}
+
+// CommandError is returned when a zfs or zpool command fails. It unwraps to
+// one of the exported errors if stderr could be classified, and to the
+// *exec.ExitError otherwise.
+type CommandError struct {
+	// Op describes what was attempted.
+	Op string
+
+	// Stderr is the error output of the command.
+	Stderr string
+
+	// Err is the classified error.
+	Err error
+}
+
+// Error implements error.
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s error: %s", e.Op, e.Stderr)
+}
+
+// Unwrap returns the classified error.
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// classify returns the error matching stderr, or nil if none match.
+func classify(stderr string) error {
+	lower := strings.ToLower(stderr)
+	for _, e := range stderrErrors {
+		if strings.Contains(lower, e.message) {
+			return e.err
+		}
+	}
+
+	return nil
+}
+
+// newCommandError wraps err from running a command in a CommandError if the
+// command exited with an error. Other errors are returned as is.
+func newCommandError(err error, format string, args ...interface{}) error {
+	exitError, ok := err.(*exec.ExitError)
+	if !ok {
+		return err
+	}
+
+	e := &CommandError{
+		Op:     fmt.Sprintf(format, args...),
+		Stderr: string(exitError.Stderr),
+		Err:    classify(string(exitError.Stderr)),
+	}
+	if e.Err == nil {
+		e.Err = exitError
+	}
+
+	return e
+}
+
+// Retryable returns true if err is transient, and the operation could
+// succeed if tried again later.
+func Retryable(err error) bool {
+	return errors.Is(err, ErrBusy)
+}
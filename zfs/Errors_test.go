@@ -0,0 +1,81 @@
+package zfs
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		stderr   string
+		expected error
+	}{
+		{"cannot open 'pool/a': dataset does not exist\n", ErrDatasetNotFound},
+		{"could not find any snapshots to destroy; check snapshot names.\n", ErrDatasetNotFound},
+		{"cannot open 'nopool': no such pool\n", ErrDatasetNotFound},
+		{"cannot destroy 'pool/a@b': snapshot has dependent clones\nuse '-R' to destroy the following datasets:\npool/clone\n", ErrHasClones},
+		{"cannot destroy snapshot pool/a@b: dataset is busy\n", ErrBusy},
+		{"cannot destroy 'pool/a@b': pool or dataset is busy\n", ErrBusy},
+		{"cannot destroy 'pool/a@b': permission denied\n", ErrPermissionDenied},
+		{"Permission denied the ZFS utilities must be run as root.\n", ErrPermissionDenied},
+		{"internal error: out of memory\n", nil},
+	}
+
+	for i, c := range cases {
+		if err := classify(c.stderr); err != c.expected {
+			t.Errorf("%d classify() returned %v, expected %v", i, err, c.expected)
+		}
+	}
+}
+
+func TestNewCommandError(t *testing.T) {
+	_, err := exec.Command("sh", "-c", "echo 'cannot destroy snapshot pool/a@b: dataset is busy' >&2; exit 1").Output()
+	err = newCommandError(err, "failed to destroy snapshot: %s", "pool/a@b")
+
+	if !errors.Is(err, ErrBusy) {
+		t.Fatalf("newCommandError() returned %v, expected ErrBusy", err)
+	}
+
+	var commandError *CommandError
+	if !errors.As(err, &commandError) {
+		t.Fatalf("newCommandError() did not return a CommandError")
+	}
+
+	expected := "failed to destroy snapshot: pool/a@b error: cannot destroy snapshot pool/a@b: dataset is busy\n"
+	if err.Error() != expected {
+		t.Fatalf("newCommandError() returned '%s', expected '%s'", err.Error(), expected)
+	}
+
+	_, err = exec.Command("sh", "-c", "echo 'something else' >&2; exit 1").Output()
+	err = newCommandError(err, "failed")
+
+	var exitError *exec.ExitError
+	if !errors.As(err, &exitError) {
+		t.Fatalf("newCommandError() did not unwrap to the exit error for unknown messages")
+	}
+
+	original := errors.New("not started")
+	if newCommandError(original, "failed") != original {
+		t.Fatalf("newCommandError() changed an error not from the command")
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{&CommandError{Err: ErrBusy}, true},
+		{&CommandError{Err: ErrHasClones}, false},
+		{&CommandError{Err: ErrPermissionDenied}, false},
+		{errors.New("dataset is busy"), false},
+		{nil, false},
+	}
+
+	for i, c := range cases {
+		if Retryable(c.err) != c.expected {
+			t.Errorf("%d Retryable() returned %v, expected %v", i, !c.expected, c.expected)
+		}
+	}
+}
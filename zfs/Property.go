@@ -0,0 +1,56 @@
+package zfs
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+type (
+	// Property is a single property value as reported by "zfs get".
+	Property struct {
+		Dataset string
+		Name    string
+		Value   string
+
+		// Source is the dataset the value is set on. For local values
+		// this is Dataset itself.
+		Source string
+	}
+)
+
+const inheritedFrom = "inherited from "
+
+// NewPropertiesFromOutput parses the output of "zfs get -H -o
+// name,property,value,source". Properties not set on the dataset or any of
+// its ancestors are left out.
+func NewPropertiesFromOutput(output []byte) ([]Property, error) {
+	properties := []Property{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 4 {
+			return nil, ErrMalformedLine
+		}
+
+		p := Property{
+			Dataset: fields[0],
+			Name:    fields[1],
+			Value:   fields[2],
+		}
+
+		switch {
+		case strings.HasPrefix(fields[3], inheritedFrom):
+			p.Source = strings.TrimPrefix(fields[3], inheritedFrom)
+		case fields[3] == "local" || fields[3] == "received":
+			p.Source = p.Dataset
+		default:
+			// Unset user properties have the source "-".
+			continue
+		}
+
+		properties = append(properties, p)
+	}
+
+	return properties, scanner.Err()
+}
@@ -0,0 +1,33 @@
+package zfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewPropertiesFromOutput(t *testing.T) {
+	output := []byte("pool\tcego:zfs-cleaner:plan\t-\t-\n" +
+		"pool/a\tcego:zfs-cleaner:plan\tlonglived\tlocal\n" +
+		"pool/a/b\tcego:zfs-cleaner:plan\tlonglived\tinherited from pool/a\n" +
+		"pool/c\tcego:zfs-cleaner:keep\t1h for 2d\treceived\n")
+
+	properties, err := NewPropertiesFromOutput(output)
+	if err != nil {
+		t.Fatalf("NewPropertiesFromOutput() returned error: %s", err.Error())
+	}
+
+	expected := []Property{
+		{Dataset: "pool/a", Name: "cego:zfs-cleaner:plan", Value: "longlived", Source: "pool/a"},
+		{Dataset: "pool/a/b", Name: "cego:zfs-cleaner:plan", Value: "longlived", Source: "pool/a"},
+		{Dataset: "pool/c", Name: "cego:zfs-cleaner:keep", Value: "1h for 2d", Source: "pool/c"},
+	}
+
+	if !reflect.DeepEqual(properties, expected) {
+		t.Fatalf("NewPropertiesFromOutput() returned %+v, expected %+v", properties, expected)
+	}
+
+	_, err = NewPropertiesFromOutput([]byte("pool cego:zfs-cleaner:plan longlived local\n"))
+	if err != ErrMalformedLine {
+		t.Fatalf("NewPropertiesFromOutput() did not detect malformed line")
+	}
+}
@@ -0,0 +1,63 @@
+package zfs
+
+import (
+	"fmt"
+)
+
+type (
+	// ReasonKind identifies the kind of rule that decided to keep a
+	// snapshot.
+	ReasonKind string
+
+	// Reason describes why a snapshot was kept.
+	Reason struct {
+		Kind   ReasonKind
+		Detail string
+
+		// Plan is the plan the reason came from. It is only set when
+		// plans are merged.
+		Plan string
+	}
+)
+
+const (
+	// ReasonProtect is used for snapshots kept by a protect rule.
+	ReasonProtect = ReasonKind("protect")
+
+	// ReasonLatest is used for snapshots kept by "keep latest".
+	ReasonLatest = ReasonKind("latest")
+
+	// ReasonOldest is used for snapshots kept by KeepOldest.
+	ReasonOldest = ReasonKind("oldest")
+
+	// ReasonHold is used for snapshots with one or more zfs holds.
+	ReasonHold = ReasonKind("hold")
+
+	// ReasonPeriod is used for snapshots kept by a "keep X for Y" period.
+	ReasonPeriod = ReasonKind("period")
+
+	// ReasonCalendar is used for snapshots kept by a calendar rule like
+	// "keep daily 14".
+	ReasonCalendar = ReasonKind("calendar")
+
+	// ReasonReplication is used for the newest snapshot common with a
+	// replication peer.
+	ReasonReplication = ReasonKind("replication")
+
+	// ReasonClones is used for snapshots with dependent clones.
+	ReasonClones = ReasonKind("clones")
+)
+
+// String implements Stringer.
+func (r Reason) String() string {
+	out := string(r.Kind)
+	if r.Detail != "" {
+		out = fmt.Sprintf("%s: %s", r.Kind, r.Detail)
+	}
+
+	if r.Plan != "" {
+		out = fmt.Sprintf("%s (plan %s)", out, r.Plan)
+	}
+
+	return out
+}
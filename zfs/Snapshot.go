@@ -14,23 +14,40 @@ type (
 		Name     string
 		Creation time.Time
 		Keep     bool
+		Reasons  []Reason
+
+		// UserRefs is the number of holds on the snapshot.
+		UserRefs int
+		GUID     string
+
+		// Used is the space in bytes unique to the snapshot.
+		Used uint64
+
+		// Clones is the names of datasets cloned from the snapshot.
+		Clones []string
 	}
 )
 
+// snapshotProperties is the properties requested from "zfs list" when
+// listing snapshots. NewSnapshotFromLine expects them in this order.
+var snapshotProperties = []string{"name", "creation", "userrefs", "guid", "used", "clones"}
+
 var (
 	// ErrMalformedLine will be returned if output from zfs is unusable.
 	ErrMalformedLine = errors.New("broken line")
 )
 
 // NewSnapshotFromLine will try to parse a line from "zfs list" and instantiate
-// a new Snapshot.
+// a new Snapshot. The line must contain the name and creation time, and can
+// optionally contain the userrefs, guid, used and clones properties - in that
+// order.
 func NewSnapshotFromLine(line string) (*Snapshot, error) {
 	if len(line) < 3 {
 		return nil, ErrMalformedLine
 	}
 
 	fields := strings.Fields(line)
-	if len(fields) != 2 {
+	if len(fields) < 2 || len(fields) > len(snapshotProperties) {
 		return nil, ErrMalformedLine
 	}
 
@@ -48,6 +65,33 @@ func NewSnapshotFromLine(line string) (*Snapshot, error) {
 		Creation: time.Unix(creation, 0),
 	}
 
+	if len(fields) > 2 {
+		s.UserRefs, err = strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, err
+		}
+
+		if s.UserRefs < 0 {
+			return nil, ErrMalformedLine
+		}
+	}
+
+	if len(fields) > 3 {
+		s.GUID = fields[3]
+	}
+
+	if len(fields) > 4 {
+		s.Used, err = strconv.ParseUint(fields[4], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// zfs prints "-" for snapshots without clones.
+	if len(fields) > 5 && fields[5] != "-" {
+		s.Clones = strings.Split(fields[5], ",")
+	}
+
 	return &s, nil
 }
 
@@ -56,6 +100,28 @@ func (s *Snapshot) String() string {
 	return fmt.Sprintf("%s:%d:%v", s.Name, s.Creation.Unix(), s.Keep)
 }
 
+// keep will mark s to be kept and record why.
+func (s *Snapshot) keep(reason Reason) {
+	s.Keep = true
+	s.Reasons = append(s.Reasons, reason)
+}
+
+// Prunable returns true if s is kept only by periods or calendar periods.
+// Such snapshots can be destroyed to reach a space target.
+func (s *Snapshot) Prunable() bool {
+	if !s.Keep {
+		return false
+	}
+
+	for _, reason := range s.Reasons {
+		if reason.Kind != ReasonPeriod && reason.Kind != ReasonCalendar {
+			return false
+		}
+	}
+
+	return true
+}
+
 // SnapshotName returns the snapshot name part of the full name. This is the
 // part after the @.
 func (s *Snapshot) SnapshotName() string {
@@ -65,3 +131,13 @@ func (s *Snapshot) SnapshotName() string {
 	}
 	return parts[1]
 }
+
+// DatasetName returns the dataset part of the full name. This is the part
+// before the @.
+func (s *Snapshot) DatasetName() string {
+	parts := strings.Split(s.Name, "@")
+	if len(parts) != 2 {
+		return ""
+	}
+	return parts[0]
+}
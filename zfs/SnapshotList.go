@@ -4,36 +4,92 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"strings"
 	"time"
 )
 
+// MaxArgumentLength is the longest single command line argument accepted by
+// the Linux kernel (MAX_ARG_STRLEN) minus the terminating zero.
+const MaxArgumentLength = 32*4096 - 1
+
 type (
 	// SnapshotList represents a list of snapshots. These will always be sorted
 	// by creation time.
 	SnapshotList []*Snapshot
 )
 
-// NewSnapshotListFromDataset will create a new SnapshotList from the output of the provided ZfsExecutor
-func (l SnapshotList) NewSnapshotListFromDataset(zfsExecutor Executor, dataset string) (SnapshotList, error) {
+// NewSnapshotListFromOutput will create a new SnapshotList from the output of
+// "zfs list". The output must be sorted by creation time, but can span several
+// datasets.
+func NewSnapshotListFromOutput(output []byte) (SnapshotList, error) {
 	list := SnapshotList{}
-	lastCreation := time.Time{}
-	output, err := zfsExecutor.GetSnapshotList(dataset)
-	if err != nil {
-		return nil, err
-	}
 	scanner := bufio.NewScanner(bytes.NewReader(output))
 	for scanner.Scan() {
 		s, err := NewSnapshotFromLine(scanner.Text())
 		if err != nil {
 			return nil, err
 		}
-		if lastCreation.Sub(s.Creation) > time.Second {
-			return nil, fmt.Errorf("output does not appear sorted. %d < %d", s.Creation.Unix(), lastCreation.Unix())
-		}
-		lastCreation = s.Creation
 		list = append(list, s)
 	}
-	return list, nil
+	return list, scanner.Err()
+}
+
+// Partition will split l into a list per dataset. Each list will be checked
+// for sort order.
+func (l SnapshotList) Partition() (map[string]SnapshotList, error) {
+	lists := make(map[string]SnapshotList)
+	for _, s := range l {
+		dataset := s.DatasetName()
+		list := lists[dataset]
+		if last := list.Latest(); last != nil && last.Creation.Sub(s.Creation) > time.Second {
+			return nil, fmt.Errorf("output does not appear sorted. %d < %d", s.Creation.Unix(), last.Creation.Unix())
+		}
+		lists[dataset] = append(list, s)
+	}
+	return lists, nil
+}
+
+// Roots returns the closest common ancestor of datasets in each pool.
+// Listing snapshots recursively from each root will include all snapshots of
+// datasets, and as few others as possible.
+func Roots(datasets []string) []string {
+	index := make(map[string]int)
+	roots := []string{}
+	for _, dataset := range datasets {
+		pool := PoolName(dataset)
+		i, found := index[pool]
+		if !found {
+			index[pool] = len(roots)
+			roots = append(roots, dataset)
+			continue
+		}
+		for !Contains(roots[i], dataset) {
+			roots[i] = roots[i][:strings.LastIndex(roots[i], "/")]
+		}
+	}
+	return roots
+}
+
+// Contains returns true if dataset is ancestor or one of its descendants.
+func Contains(ancestor string, dataset string) bool {
+	return dataset == ancestor || strings.HasPrefix(dataset, ancestor+"/")
+}
+
+// PoolName returns the name of the pool containing dataset.
+func PoolName(dataset string) string {
+	return strings.SplitN(dataset, "/", 2)[0]
+}
+
+// Copy returns a copy of l with copies of all snapshots. Changes to the keep
+// state of the copy will not affect l.
+func (l SnapshotList) Copy() SnapshotList {
+	list := make(SnapshotList, len(l))
+	for i, s := range l {
+		snapshot := *s
+		snapshot.Reasons = append([]Reason(nil), s.Reasons...)
+		list[i] = &snapshot
+	}
+	return list
 }
 
 // Next will retrieve a pointer to the next Snapshot in l where the snapshot
@@ -76,14 +132,25 @@ func (l SnapshotList) String() string {
 
 // KeepLatest will keep the num latest snapshots.
 func (l SnapshotList) KeepLatest(num int) {
+	l.KeepLatestRule(num, fmt.Sprintf("latest %d", num))
+}
+
+// KeepLatestRule works like KeepLatest, but records rule as the reason for
+// keeping each snapshot.
+func (l SnapshotList) KeepLatestRule(num int, rule string) {
 	start := len(l) - num
 
 	if start < 0 {
 		start = 0
 	}
 
+	reason := Reason{
+		Kind:   ReasonLatest,
+		Detail: rule,
+	}
+
 	for i := start; i < len(l); i++ {
-		l[i].Keep = true
+		l[i].keep(reason)
 	}
 }
 
@@ -96,41 +163,172 @@ func (l SnapshotList) KeepNamed(names []string) {
 	}
 
 	for _, snapshot := range l {
-		if index[snapshot.SnapshotName()] {
-			snapshot.Keep = true
+		name := snapshot.SnapshotName()
+		if index[name] {
+			snapshot.keep(Reason{Kind: ReasonProtect, Detail: name})
+		}
+	}
+}
+
+// Matcher matches snapshot names, not including the dataset.
+type Matcher interface {
+	Match(name string) bool
+	String() string
+}
+
+// KeepMatching keeps all snapshots with a name matched by matcher.
+func (l SnapshotList) KeepMatching(matcher Matcher) {
+	for _, snapshot := range l {
+		if matcher.Match(snapshot.SnapshotName()) {
+			snapshot.keep(Reason{Kind: ReasonProtect, Detail: matcher.String()})
+		}
+	}
+}
+
+// DoomedUsage returns the space used by snapshots in l not kept.
+func (l SnapshotList) DoomedUsage() uint64 {
+	var used uint64
+	for _, snapshot := range l {
+		if !snapshot.Keep {
+			used += snapshot.Used
+		}
+	}
+
+	return used
+}
+
+// Prune will stop keeping prunable snapshots, oldest first, until the space
+// used by the snapshots pruned reaches need. The number of snapshots pruned
+// and the space they use is returned. Space is estimated from the used
+// property, the actual space freed will often be larger.
+func (l SnapshotList) Prune(need uint64) (int, uint64) {
+	pruned := 0
+	var freed uint64
+	for _, snapshot := range l {
+		if freed >= need {
+			break
+		}
+
+		if !snapshot.Prunable() {
+			continue
+		}
+
+		snapshot.Keep = false
+		snapshot.Reasons = nil
+		freed += snapshot.Used
+		pruned++
+	}
+
+	return pruned, freed
+}
+
+// Split will split the list in snapshots where match returns true, and
+// snapshots where it returns false. Order is preserved.
+func (l SnapshotList) Split(match func(snapshot *Snapshot) bool) (SnapshotList, SnapshotList) {
+	matched := SnapshotList{}
+	unmatched := SnapshotList{}
+	for _, snapshot := range l {
+		if match(snapshot) {
+			matched = append(matched, snapshot)
+		} else {
+			unmatched = append(unmatched, snapshot)
 		}
 	}
+
+	return matched, unmatched
 }
 
 // KeepOldest keeps the num oldest snapshots.
 func (l SnapshotList) KeepOldest(num int) {
+	reason := Reason{
+		Kind:   ReasonOldest,
+		Detail: fmt.Sprintf("oldest %d", num),
+	}
+
 	for i := 0; i < num && i < len(l); i++ {
-		l[i].Keep = true
+		l[i].keep(reason)
 	}
 }
 
 // KeepHolds will keep all snapshots with zfs holds on it.
-func (l SnapshotList) KeepHolds(zfsExecutor Executor) error {
+func (l SnapshotList) KeepHolds() {
 	for _, snapshot := range l {
-		hasHolds, err := zfsExecutor.HasHolds(snapshot.Name)
-		if err != nil {
-			return err
+		if snapshot.UserRefs > 0 {
+			snapshot.keep(Reason{
+				Kind:   ReasonHold,
+				Detail: fmt.Sprintf("%d user references", snapshot.UserRefs),
+			})
 		}
-		if hasHolds {
-			snapshot.Keep = true
+	}
+}
+
+// KeepClones will keep all snapshots with clones, unless allowed returns
+// true for every clone of the snapshot.
+func (l SnapshotList) KeepClones(allowed func(clone string) bool) {
+	for _, snapshot := range l {
+		if len(snapshot.Clones) == 0 {
+			continue
+		}
+
+		for _, clone := range snapshot.Clones {
+			if !allowed(clone) {
+				snapshot.keep(Reason{
+					Kind:   ReasonClones,
+					Detail: "has clones " + strings.Join(snapshot.Clones, ", "),
+				})
+				break
+			}
 		}
 	}
+}
+
+// KeepLatestCommon will keep the newest snapshot also present in peer. peer
+// maps snapshot names (the part after @) to GUIDs. If both sides know the
+// GUID, the GUIDs must match too. The common snapshot is returned, or nil if
+// there is none. source is used for describing peer in the reason.
+func (l SnapshotList) KeepLatestCommon(peer map[string]string, source string) *Snapshot {
+	for i := len(l) - 1; i >= 0; i-- {
+		s := l[i]
+
+		guid, found := peer[s.SnapshotName()]
+		if !found {
+			continue
+		}
+
+		if guid != "" && s.GUID != "" && guid != s.GUID {
+			continue
+		}
+
+		s.keep(Reason{
+			Kind:   ReasonReplication,
+			Detail: fmt.Sprintf("newest snapshot common with %s", source),
+		})
+
+		return s
+	}
+
 	return nil
 }
 
 // Sieve will mark snapshots to keep according to start time and frequency.
 func (l SnapshotList) Sieve(start time.Time, frequency time.Duration) {
+	l.SieveRule(start, frequency, fmt.Sprintf("every %s", frequency))
+}
+
+// SieveRule works like Sieve, but records rule as the reason for keeping
+// each snapshot, together with the slot the snapshot filled.
+func (l SnapshotList) SieveRule(start time.Time, frequency time.Duration, rule string) {
 	// The ZFS resolution on creation time is one second. If we get a frequency
 	// below one second, we have to keep everything after start.
 	if frequency < time.Second {
+		reason := Reason{
+			Kind:   ReasonPeriod,
+			Detail: fmt.Sprintf("%s, everything since %s", rule, start.Format(time.RFC3339)),
+		}
+
 		for _, s := range l {
 			if s.Creation.Sub(start) >= 0 {
-				s.Keep = true
+				s.keep(reason)
 			}
 		}
 		return
@@ -165,8 +363,13 @@ func (l SnapshotList) Sieve(start time.Time, frequency time.Duration) {
 	// passed, this does nothing since offset will be zero.
 	start = start.Add(-offset)
 
-	for s := l.Next(start); s != nil; s = l.Next(s.Creation.Add(frequency)) {
-		s.Keep = true
+	from := start
+	for s := l.Next(from); s != nil; s = l.Next(from) {
+		s.keep(Reason{
+			Kind:   ReasonPeriod,
+			Detail: fmt.Sprintf("%s, slot from %s", rule, from.Format(time.RFC3339)),
+		})
+		from = s.Creation.Add(frequency)
 	}
 }
 
@@ -174,5 +377,44 @@ func (l SnapshotList) Sieve(start time.Time, frequency time.Duration) {
 func (l SnapshotList) ResetSieve() {
 	for _, s := range l {
 		s.Keep = false
+		s.Reasons = nil
+	}
+}
+
+// Batches will split l into lists of at most size snapshots from the same
+// dataset, suitable for a single "zfs destroy dataset@a,b,c" call. No batch
+// will produce an argument longer than MaxArgumentLength. The order of l is
+// preserved within each batch.
+func (l SnapshotList) Batches(size int) []SnapshotList {
+	if size < 1 {
+		size = 1
 	}
+
+	batches := []SnapshotList{}
+	index := make(map[string]int)
+	length := make(map[string]int)
+
+	for _, s := range l {
+		dataset := s.DatasetName()
+		name := s.SnapshotName()
+
+		i, found := index[dataset]
+		if found {
+			// Account for the comma separating names.
+			newLength := length[dataset] + 1 + len(name)
+
+			if len(batches[i]) < size && newLength <= MaxArgumentLength {
+				batches[i] = append(batches[i], s)
+				length[dataset] = newLength
+
+				continue
+			}
+		}
+
+		index[dataset] = len(batches)
+		length[dataset] = len(dataset) + 1 + len(name)
+		batches = append(batches, SnapshotList{s})
+	}
+
+	return batches
 }
@@ -2,6 +2,7 @@ package zfs
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -49,37 +50,63 @@ func (t *testExecutor) HasZFSCommand() error {
 	return nil
 }
 
-func (t *testExecutor) GetSnapshotList(dataset string) ([]byte, error) {
-	return t.getSnapshotListResult, t.getSnapshotListError
+func (t *testExecutor) ListSnapshots(root string) (SnapshotList, error) {
+	if t.getSnapshotListError != nil {
+		return nil, t.getSnapshotListError
+	}
+	return NewSnapshotListFromOutput(t.getSnapshotListResult)
 }
 
 func (t *testExecutor) GetFilesystems() ([]byte, error) {
 	panic("implement me")
 }
 
+func (t *testExecutor) GetUserProperties(names []string) ([]Property, error) {
+	panic("implement me")
+}
+
+func (t *testExecutor) GetUsage(datasets []string) (map[string]Usage, error) {
+	panic("implement me")
+}
+
+func (t *testExecutor) GetPoolSpace(pool string) (PoolSpace, error) {
+	panic("implement me")
+}
+
 func (t *testExecutor) HasSnapshot(dataset string) (bool, error) {
 	panic("implement me")
 }
 
-func (t *testExecutor) HasHolds(dataset string) (bool, error) {
-	return false, nil
+func (t *testExecutor) GetGUID(snapshot string) (string, error) {
+	panic("implement me")
 }
 
 func (t *testExecutor) DestroySnapshot(dataset string) ([]byte, error) {
 	return nil, nil
 }
 
+func (t *testExecutor) DestroySnapshots(dataset string, names []string) ([]byte, error) {
+	return nil, nil
+}
+
+func (t *testExecutor) DestroySnapshotRecursive(snapshot string) ([]byte, error) {
+	panic("implement me")
+}
+
+func (t *testExecutor) EstimateReclaim(dataset string, names []string) (uint64, error) {
+	panic("implement me")
+}
+
 func TestNewSnapshotListFromOutput(t *testing.T) {
 	zfsExecutor := &testExecutor{
-		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570
-playground/fs1@snap2	1492989572
-playground/fs1@snap3	1492989573
-playground/fs1@snap4	1492989574
-playground/fs1@snap5	1492989587
+		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570	0	1001
+playground/fs1@snap2	1492989572	0	1002
+playground/fs1@snap3	1492989573	2	1003
+playground/fs1@snap4	1492989574	0	1004
+playground/fs1@snap5	1492989587	0	1005
 `),
 	}
-	list := SnapshotList{}
-	s, err := list.NewSnapshotListFromDataset(zfsExecutor, "playground/fs1")
+	s, err := zfsExecutor.ListSnapshots("playground")
 	if err != nil {
 		t.Fatalf("NewSnapshotListFromOutput() errored: %s", err.Error())
 	}
@@ -87,21 +114,50 @@ playground/fs1@snap5	1492989587
 	if len(s) != 5 {
 		t.Fatalf("NewSnapshotListFromOutput() returned wrong number of snapshots. Got %d, expected %d", len(s), 5)
 	}
+
+	if s[2].UserRefs != 2 || s[2].GUID != "1003" {
+		t.Fatalf("NewSnapshotListFromOutput() did not parse userrefs and guid: %+v", s[2])
+	}
+}
+
+func TestPartition(t *testing.T) {
+	l, err := NewSnapshotListFromOutput(exampleOutput1)
+	if err != nil {
+		t.Fatalf("NewSnapshotListFromOutput() errored: %s", err.Error())
+	}
+
+	lists, err := l.Partition()
+	if err != nil {
+		t.Fatalf("Partition() errored: %s", err.Error())
+	}
+
+	if len(lists) != 2 {
+		t.Fatalf("Partition() returned %d lists, expected 2", len(lists))
+	}
+
+	if len(lists["playground/fs1"]) != 9 || len(lists["playground/fs2"]) != 3 {
+		t.Fatalf("Partition() returned wrong lists: %v", lists)
+	}
 }
 
-func TestNewSnapshotListFromOutputUnsorted(t *testing.T) {
+func TestPartitionUnsorted(t *testing.T) {
 	zfsExecutor := &testExecutor{
 		getSnapshotListResult: []byte(`playground/fs1@snap1	1492989570
 playground/fs1@snap2	1492989572
 playground/fs1@snap4	1492989594
+playground/fs2@snap1	1492989573
 playground/fs1@snap3	1492989573
 playground/fs1@snap5	1492989587
 `),
 	}
-	list := SnapshotList{}
-	_, err := list.NewSnapshotListFromDataset(zfsExecutor, "playground/fs1")
+	l, err := zfsExecutor.ListSnapshots("playground")
+	if err != nil {
+		t.Fatalf("ListSnapshots() errored: %s", err.Error())
+	}
+
+	_, err = l.Partition()
 	if err == nil {
-		t.Fatalf("NewSnapshotListFromOutput() did not err on unsorted input")
+		t.Fatalf("Partition() did not err on unsorted input")
 	}
 }
 
@@ -109,13 +165,52 @@ func TestNewSnapshotListFromOutputBroken(t *testing.T) {
 	zfsExecutor := &testExecutor{
 		getSnapshotListResult: []byte(`three argument yay`),
 	}
-	list := SnapshotList{}
-	_, err := list.NewSnapshotListFromDataset(zfsExecutor, "playground/fs1")
+	_, err := zfsExecutor.ListSnapshots("playground")
 	if err == nil {
 		t.Fatalf("NewSnapshotListFromOutput() did not err on broken input")
 	}
 }
 
+func TestRoots(t *testing.T) {
+	cases := []struct {
+		datasets []string
+		expected []string
+	}{
+		{[]string{"pool/a", "pool/b/c", "backup", "backup/a", "other/a"}, []string{"pool", "backup", "other/a"}},
+		{[]string{"pool/a/b", "pool/a/c/d", "pool/a/c"}, []string{"pool/a"}},
+		{[]string{"pool/ab", "pool/a"}, []string{"pool"}},
+		{[]string{"pool/a/b", "pool/a/b/c"}, []string{"pool/a/b"}},
+	}
+
+	for i, c := range cases {
+		roots := Roots(c.datasets)
+		if strings.Join(roots, " ") != strings.Join(c.expected, " ") {
+			t.Errorf("%d Roots() returned %v, expected %v", i, roots, c.expected)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	l := SnapshotList{newSnapshotFromLine("fs@a 0")}
+	c := l.Copy()
+	c.KeepLatest(1)
+
+	if l[0].Keep || len(l[0].Reasons) != 0 {
+		t.Fatalf("Copy() did not copy snapshots")
+	}
+}
+
+func TestKeepHolds(t *testing.T) {
+	l := SnapshotList{
+		newSnapshotFromLine("fs@a 0 0"),
+		newSnapshotFromLine("fs@b 1 1"),
+		newSnapshotFromLine("fs@c 2 0"),
+	}
+
+	l.KeepHolds()
+	testKeep(0, t, l, []bool{false, true, false})
+}
+
 func TestSnapshotListNext(t *testing.T) {
 	cases := []struct {
 		from     int64
@@ -325,3 +420,265 @@ func TestKeepNamed(t *testing.T) {
 		testKeep(ii, t, list, c.expected)
 	}
 }
+
+// prefixMatcher is a Matcher matching names by prefix.
+type prefixMatcher string
+
+func (m prefixMatcher) Match(name string) bool {
+	return strings.HasPrefix(name, string(m))
+}
+
+func (m prefixMatcher) String() string {
+	return "prefix:" + string(m)
+}
+
+func TestKeepMatching(t *testing.T) {
+	l := SnapshotList{
+		newSnapshotFromLine("fs@release-1 0"),
+		newSnapshotFromLine("fs@daily-1 1"),
+		newSnapshotFromLine("fs@release-2 2"),
+	}
+
+	l.KeepMatching(prefixMatcher("release-"))
+	testKeep(0, t, l, []bool{true, false, true})
+
+	if l[0].Reasons[0].String() != "protect: prefix:release-" {
+		t.Fatalf("KeepMatching() recorded wrong reason: %s", l[0].Reasons[0].String())
+	}
+}
+
+func TestKeepReasons(t *testing.T) {
+	l := SnapshotList{
+		newSnapshotFromLine("fs@a 0"),
+		newSnapshotFromLine("fs@b 3600"),
+		newSnapshotFromLine("fs@c 7200"),
+	}
+
+	l.KeepNamed([]string{"a"})
+	l.KeepLatest(1)
+	l.SieveRule(time.Unix(3600, 0), time.Hour, "keep 1h for 2h")
+
+	expected := [][]ReasonKind{
+		{ReasonProtect},
+		{ReasonPeriod},
+		{ReasonLatest, ReasonPeriod},
+	}
+
+	for i, kinds := range expected {
+		if len(l[i].Reasons) != len(kinds) {
+			t.Fatalf("%d got %d reasons, expected %d: %v", i, len(l[i].Reasons), len(kinds), l[i].Reasons)
+		}
+
+		for j, kind := range kinds {
+			if l[i].Reasons[j].Kind != kind {
+				t.Fatalf("%d reason %d is %s, expected %s", i, j, l[i].Reasons[j].Kind, kind)
+			}
+		}
+	}
+
+	if !strings.HasPrefix(l[1].Reasons[0].Detail, "keep 1h for 2h, slot from ") {
+		t.Fatalf("period reason did not include rule and slot: '%s'", l[1].Reasons[0].Detail)
+	}
+
+	l.ResetSieve()
+
+	for i, s := range l {
+		if len(s.Reasons) != 0 {
+			t.Fatalf("%d ResetSieve() did not clear reasons", i)
+		}
+	}
+}
+
+func TestBatches(t *testing.T) {
+	l := SnapshotList{
+		newSnapshotFromLine("fs1@a 1"),
+		newSnapshotFromLine("fs1@b 2"),
+		newSnapshotFromLine("fs2@c 3"),
+		newSnapshotFromLine("fs1@d 4"),
+		newSnapshotFromLine("fs2@e 5"),
+	}
+
+	cases := []struct {
+		size     int
+		expected []string
+	}{
+		{0, []string{"[ fs1@a:1:false ]", "[ fs1@b:2:false ]", "[ fs2@c:3:false ]", "[ fs1@d:4:false ]", "[ fs2@e:5:false ]"}},
+		{1, []string{"[ fs1@a:1:false ]", "[ fs1@b:2:false ]", "[ fs2@c:3:false ]", "[ fs1@d:4:false ]", "[ fs2@e:5:false ]"}},
+		{2, []string{"[ fs1@a:1:false fs1@b:2:false ]", "[ fs2@c:3:false fs2@e:5:false ]", "[ fs1@d:4:false ]"}},
+		{100, []string{"[ fs1@a:1:false fs1@b:2:false fs1@d:4:false ]", "[ fs2@c:3:false fs2@e:5:false ]"}},
+	}
+
+	for i, c := range cases {
+		batches := l.Batches(c.size)
+		if len(batches) != len(c.expected) {
+			t.Fatalf("%d Batches() returned %d batches, expected %d: %v", i, len(batches), len(c.expected), batches)
+		}
+
+		for j, batch := range batches {
+			if batch.String() != c.expected[j] {
+				t.Fatalf("%d batch %d is %s, expected %s", i, j, batch.String(), c.expected[j])
+			}
+		}
+	}
+}
+
+func TestBatchesArgumentLength(t *testing.T) {
+	name := strings.Repeat("x", 200)
+	l := SnapshotList{}
+	for i := 0; i < 2000; i++ {
+		l = append(l, newSnapshotFromLine(fmt.Sprintf("pool/fs@%s%d %d", name, i, i)))
+	}
+
+	batches := l.Batches(len(l))
+	if len(batches) < 2 {
+		t.Fatalf("Batches() did not split batch exceeding argument length")
+	}
+
+	total := 0
+	for i, batch := range batches {
+		names := []string{}
+		for _, s := range batch {
+			names = append(names, s.SnapshotName())
+		}
+
+		length := len("pool/fs@" + strings.Join(names, ","))
+		if length > MaxArgumentLength {
+			t.Fatalf("%d batch argument is %d bytes long", i, length)
+		}
+
+		total += len(batch)
+	}
+
+	if total != len(l) {
+		t.Fatalf("Batches() lost snapshots, got %d, expected %d", total, len(l))
+	}
+}
+
+func TestKeepLatestCommon(t *testing.T) {
+	l := SnapshotList{
+		newSnapshotFromLine("fs@a 1 0 1001"),
+		newSnapshotFromLine("fs@b 2 0 1002"),
+		newSnapshotFromLine("fs@c 3 0 1003"),
+		newSnapshotFromLine("fs@d 4 0 1004"),
+	}
+
+	cases := []struct {
+		peer     map[string]string
+		expected []bool
+	}{
+		{map[string]string{}, []bool{false, false, false, false}},
+		{map[string]string{"a": "", "b": ""}, []bool{false, true, false, false}},
+		{map[string]string{"a": "1001", "c": "1003"}, []bool{false, false, true, false}},
+		// c was recreated on the peer, so the newest common is a.
+		{map[string]string{"a": "1001", "c": "2003"}, []bool{true, false, false, false}},
+		{map[string]string{"d": "", "e": ""}, []bool{false, false, false, true}},
+	}
+
+	for i, c := range cases {
+		l.ResetSieve()
+		common := l.KeepLatestCommon(c.peer, "backup")
+		testKeep(i, t, l, c.expected)
+
+		if (common == nil) != (len(c.peer) == 0) {
+			t.Fatalf("%d KeepLatestCommon() returned %v", i, common)
+		}
+	}
+
+	if l[3].Reasons[0].Kind != ReasonReplication {
+		t.Fatalf("KeepLatestCommon() recorded wrong reason: %s", l[3].Reasons[0])
+	}
+}
+
+func TestSplit(t *testing.T) {
+	l := SnapshotList{
+		newSnapshotFromLine("fs@auto-1 0"),
+		newSnapshotFromLine("fs@manual 1"),
+		newSnapshotFromLine("fs@auto-2 2"),
+	}
+
+	matched, unmatched := l.Split(func(snapshot *Snapshot) bool {
+		return strings.HasPrefix(snapshot.SnapshotName(), "auto-")
+	})
+
+	if len(matched) != 2 || matched[0] != l[0] || matched[1] != l[2] {
+		t.Fatalf("Split() returned wrong matched list: %s", matched.String())
+	}
+
+	if len(unmatched) != 1 || unmatched[0] != l[1] {
+		t.Fatalf("Split() returned wrong unmatched list: %s", unmatched.String())
+	}
+}
+
+func TestPrune(t *testing.T) {
+	l := SnapshotList{
+		newSnapshotFromLine("fs@a 0 0 1 100"),
+		newSnapshotFromLine("fs@b 1 1 2 100"),
+		newSnapshotFromLine("fs@c 2 0 3 100"),
+		newSnapshotFromLine("fs@d 3 0 4 100"),
+		newSnapshotFromLine("fs@e 4 0 5 100"),
+	}
+
+	l.Sieve(time.Unix(0, 0), 0)
+	l.KeepHolds()
+	l[3].Keep = false
+	l.KeepLatest(1)
+
+	if l.DoomedUsage() != 100 {
+		t.Fatalf("DoomedUsage() returned %d, expected 100", l.DoomedUsage())
+	}
+
+	pruned, freed := l.Prune(150)
+	if pruned != 2 || freed != 200 {
+		t.Fatalf("Prune() returned %d, %d - expected 2, 200", pruned, freed)
+	}
+
+	// Held and latest snapshots must never be pruned.
+	testKeep(0, t, l, []bool{false, true, false, false, true})
+
+	pruned, freed = l.Prune(1000)
+	if pruned != 0 || freed != 0 {
+		t.Fatalf("Prune() returned %d, %d - expected 0, 0", pruned, freed)
+	}
+}
+
+func TestReasonString(t *testing.T) {
+	cases := []struct {
+		reason   Reason
+		expected string
+	}{
+		{Reason{Kind: ReasonHold}, "hold"},
+		{Reason{Kind: ReasonLatest, Detail: "latest 1"}, "latest: latest 1"},
+		{Reason{Kind: ReasonLatest, Detail: "latest 1", Plan: "buh"}, "latest: latest 1 (plan buh)"},
+		{Reason{Kind: ReasonHold, Plan: "buh"}, "hold (plan buh)"},
+	}
+
+	for i, c := range cases {
+		if c.reason.String() != c.expected {
+			t.Errorf("%d String() returned '%s', expected '%s'", i, c.reason.String(), c.expected)
+		}
+	}
+}
+
+func TestKeepClones(t *testing.T) {
+	l := SnapshotList{
+		&Snapshot{Name: "fs@a"},
+		&Snapshot{Name: "fs@b", Clones: []string{"pool/ci/1"}},
+		&Snapshot{Name: "fs@c", Clones: []string{"pool/ci/2", "pool/data"}},
+		&Snapshot{Name: "fs@d", Clones: []string{"pool/data"}},
+	}
+
+	l.KeepClones(func(clone string) bool {
+		return strings.HasPrefix(clone, "pool/ci/")
+	})
+
+	expected := []bool{false, false, true, true}
+	for i, s := range l {
+		if s.Keep != expected[i] {
+			t.Errorf("%d KeepClones() set keep %v for %s", i, s.Keep, s.Name)
+		}
+	}
+
+	if l[2].Reasons[0].String() != "clones: has clones pool/ci/2, pool/data" {
+		t.Fatalf("KeepClones() recorded wrong reason: %s", l[2].Reasons[0].String())
+	}
+}
@@ -1,15 +1,16 @@
 package zfs
 
 import (
+	"reflect"
 	"testing"
 	"time"
 )
 
 var (
-	s0 = Snapshot{"s0", time.Unix(0, 0), false}
-	s1 = Snapshot{"s1", time.Unix(1491918988, 0), false}
-	s2 = Snapshot{"s2", time.Unix(1491918990, 0), false}
-	s3 = Snapshot{"s3", time.Unix(1491919188, 0), false}
+	s0 = Snapshot{Name: "s0", Creation: time.Unix(0, 0)}
+	s1 = Snapshot{Name: "s1", Creation: time.Unix(1491918988, 0)}
+	s2 = Snapshot{Name: "s2", Creation: time.Unix(1491918990, 0)}
+	s3 = Snapshot{Name: "s3", Creation: time.Unix(1491919188, 0)}
 )
 
 const (
@@ -29,6 +30,12 @@ func TestNewSnapshotFromLine(t *testing.T) {
 		{"s1 -1", nil, ErrMalformedLine},
 		{"non integer", nil, ErrMalformedLine},
 		{"too many fields", nil, ErrMalformedLine},
+		{"s1 1491918988 0 1234", &s1, nil},
+		{"s1 1491918988 -1 1234", nil, ErrMalformedLine},
+		{"s1 1491918988 0 1234 4096", &s1, nil},
+		{"s1 1491918988 0 1234 -1", nil, ErrMalformedLine},
+		{"s1 1491918988 0 1234 4096 -", &s1, nil},
+		{"s1 1491918988 0 1234 4096 pool/clone extra", nil, ErrMalformedLine},
 	}
 
 	for i, c := range cases {
@@ -72,3 +79,61 @@ func TestSnapshotName(t *testing.T) {
 		}
 	}
 }
+
+func TestSnapshotUsed(t *testing.T) {
+	s, err := NewSnapshotFromLine("fs@a 1491918988 0 1234 4096")
+	if err != nil {
+		t.Fatalf("NewSnapshotFromLine() returned error: %s", err.Error())
+	}
+
+	if s.Used != 4096 {
+		t.Fatalf("NewSnapshotFromLine() set Used to %d, expected 4096", s.Used)
+	}
+}
+
+func TestSnapshotClones(t *testing.T) {
+	cases := []struct {
+		line     string
+		expected []string
+	}{
+		{"fs@a 1491918988 0 1234 4096", nil},
+		{"fs@a 1491918988 0 1234 4096 -", nil},
+		{"fs@a 1491918988 0 1234 4096 pool/ci/1", []string{"pool/ci/1"}},
+		{"fs@a 1491918988 0 1234 4096 pool/ci/1,pool/ci/2", []string{"pool/ci/1", "pool/ci/2"}},
+	}
+
+	for i, c := range cases {
+		s, err := NewSnapshotFromLine(c.line)
+		if err != nil {
+			t.Fatalf("%d NewSnapshotFromLine() returned error: %s", i, err.Error())
+		}
+
+		if !reflect.DeepEqual(s.Clones, c.expected) {
+			t.Errorf("%d NewSnapshotFromLine() set Clones to %v, expected %v", i, s.Clones, c.expected)
+		}
+	}
+}
+
+func TestPrunable(t *testing.T) {
+	cases := []struct {
+		keep     bool
+		reasons  []Reason
+		expected bool
+	}{
+		{false, nil, false},
+		{true, []Reason{{Kind: ReasonPeriod}}, true},
+		{true, []Reason{{Kind: ReasonPeriod}, {Kind: ReasonCalendar}}, true},
+		{true, []Reason{{Kind: ReasonPeriod}, {Kind: ReasonLatest}}, false},
+		{true, []Reason{{Kind: ReasonProtect}}, false},
+		{true, []Reason{{Kind: ReasonHold}}, false},
+		{true, []Reason{{Kind: ReasonCalendar}, {Kind: ReasonReplication}}, false},
+		{true, []Reason{{Kind: ReasonPeriod}, {Kind: ReasonClones}}, false},
+	}
+
+	for i, c := range cases {
+		s := &Snapshot{Keep: c.keep, Reasons: c.reasons}
+		if s.Prunable() != c.expected {
+			t.Errorf("%d Prunable() did not return %v", i, c.expected)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package zfs
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Usage is the space used by a dataset in bytes.
+	Usage struct {
+		Used            uint64
+		UsedBySnapshots uint64
+	}
+
+	// PoolSpace is the size and free space of a pool in bytes.
+	PoolSpace struct {
+		Free uint64
+		Size uint64
+	}
+)
+
+// NewUsageFromOutput parses the output of "zfs get -H -p -o
+// name,property,value used,usedbysnapshots".
+func NewUsageFromOutput(output []byte) (map[string]Usage, error) {
+	usage := make(map[string]Usage)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			return nil, ErrMalformedLine
+		}
+
+		value, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		u := usage[fields[0]]
+		switch fields[1] {
+		case "used":
+			u.Used = value
+		case "usedbysnapshots":
+			u.UsedBySnapshots = value
+		default:
+			return nil, ErrMalformedLine
+		}
+		usage[fields[0]] = u
+	}
+
+	return usage, scanner.Err()
+}
+
+// NewPoolSpaceFromOutput parses the output of "zpool list -H -p -o
+// free,size".
+func NewPoolSpaceFromOutput(output []byte) (PoolSpace, error) {
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 {
+		return PoolSpace{}, ErrMalformedLine
+	}
+
+	free, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return PoolSpace{}, err
+	}
+
+	size, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return PoolSpace{}, err
+	}
+
+	return PoolSpace{Free: free, Size: size}, nil
+}
+
+// NewReclaimFromOutput parses the output of "zfs destroy -nvp" and returns
+// the number of bytes zfs estimates would be reclaimed.
+func NewReclaimFromOutput(output []byte) (uint64, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "reclaim" {
+			continue
+		}
+
+		return strconv.ParseUint(fields[1], 10, 64)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, ErrMalformedLine
+}
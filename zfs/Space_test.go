@@ -0,0 +1,70 @@
+package zfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewUsageFromOutput(t *testing.T) {
+	output := []byte("pool/a\tused\t3000\npool/a\tusedbysnapshots\t1000\npool/b\tused\t10\n")
+
+	usage, err := NewUsageFromOutput(output)
+	if err != nil {
+		t.Fatalf("NewUsageFromOutput() returned error: %s", err.Error())
+	}
+
+	expected := map[string]Usage{
+		"pool/a": {Used: 3000, UsedBySnapshots: 1000},
+		"pool/b": {Used: 10},
+	}
+	if !reflect.DeepEqual(usage, expected) {
+		t.Fatalf("NewUsageFromOutput() returned %+v, expected %+v", usage, expected)
+	}
+
+	cases := []string{"pool/a\tused\n", "pool/a\tused\t-1\n", "pool/a\tavailable\t1\n"}
+	for i, c := range cases {
+		_, err = NewUsageFromOutput([]byte(c))
+		if err == nil {
+			t.Errorf("%d NewUsageFromOutput() did not return error", i)
+		}
+	}
+}
+
+func TestNewPoolSpaceFromOutput(t *testing.T) {
+	space, err := NewPoolSpaceFromOutput([]byte("100\t1000\n"))
+	if err != nil {
+		t.Fatalf("NewPoolSpaceFromOutput() returned error: %s", err.Error())
+	}
+
+	if space != (PoolSpace{Free: 100, Size: 1000}) {
+		t.Fatalf("NewPoolSpaceFromOutput() returned %+v", space)
+	}
+
+	cases := []string{"", "100\n", "a\t1000\n", "100\tb\n"}
+	for i, c := range cases {
+		_, err = NewPoolSpaceFromOutput([]byte(c))
+		if err == nil {
+			t.Errorf("%d NewPoolSpaceFromOutput() did not return error", i)
+		}
+	}
+}
+
+func TestNewReclaimFromOutput(t *testing.T) {
+	output := []byte("destroy\tpool/a@snap1\ndestroy\tpool/a@snap2\nreclaim\t4096\n")
+	reclaim, err := NewReclaimFromOutput(output)
+	if err != nil {
+		t.Fatalf("NewReclaimFromOutput() returned error: %s", err.Error())
+	}
+
+	if reclaim != 4096 {
+		t.Fatalf("NewReclaimFromOutput() returned %d, expected 4096", reclaim)
+	}
+
+	cases := []string{"", "destroy\tpool/a@snap1\n", "reclaim\tmany\n"}
+	for i, c := range cases {
+		_, err = NewReclaimFromOutput([]byte(c))
+		if err == nil {
+			t.Errorf("%d NewReclaimFromOutput() did not return error", i)
+		}
+	}
+}
@@ -9,22 +9,30 @@ import (
 
 type Executor interface {
 	HasZFSCommand() error
-	GetSnapshotList(dataset string) ([]byte, error)
+	ListSnapshots(root string) (SnapshotList, error)
 	GetFilesystems() ([]byte, error)
+	GetUserProperties(names []string) ([]Property, error)
+	GetUsage(datasets []string) (map[string]Usage, error)
+	GetPoolSpace(pool string) (PoolSpace, error)
 	HasSnapshot(dataset string) (bool, error)
-	HasHolds(dataset string) (bool, error)
+	GetGUID(snapshot string) (string, error)
 	DestroySnapshot(dataset string) ([]byte, error)
+	DestroySnapshots(dataset string, names []string) ([]byte, error)
+	DestroySnapshotRecursive(snapshot string) ([]byte, error)
+	EstimateReclaim(dataset string, names []string) (uint64, error)
 }
 
 var _ Executor = (*executorImpl)(nil)
 
 type executorImpl struct {
-	zfsCommandName string
+	zfsCommandName   string
+	zpoolCommandName string
 }
 
 func NewExecutor() Executor {
 	return &executorImpl{
-		zfsCommandName: "/sbin/zfs",
+		zfsCommandName:   "/sbin/zfs",
+		zpoolCommandName: "/sbin/zpool",
 	}
 }
 
@@ -39,63 +47,100 @@ func (z *executorImpl) HasZFSCommand() error {
 	return fmt.Errorf("ZFS command %s not found", z.zfsCommandName)
 }
 
-func (z *executorImpl) GetSnapshotList(dataset string) ([]byte, error) {
-	commandArguments := []string{"list", "-t", "snapshot", "-o", "name,creation", "-s", "creation", "-d", "1", "-H", "-p", "-r", dataset}
+func (z *executorImpl) ListSnapshots(root string) (SnapshotList, error) {
+	commandArguments := []string{"list", "-t", "snapshot", "-o", strings.Join(snapshotProperties, ","), "-s", "creation", "-H", "-p", "-r", root}
 	output, err := exec.Command(z.zfsCommandName, commandArguments...).Output()
-	if exitError, ok := err.(*exec.ExitError); ok {
-		return nil, fmt.Errorf("failed to get snapshot list for dataset: %s error: %s", dataset, exitError.Stderr)
-	}
 	if err != nil {
-		return nil, err
+		return nil, newCommandError(err, "failed to get snapshot list for dataset: %s", root)
 	}
-	return output, nil
+	return NewSnapshotListFromOutput(output)
 }
 
 func (z *executorImpl) GetFilesystems() ([]byte, error) {
 	commandArguments := []string{"list", "-t", "filesystem", "-o", "name", "-H"}
 	output, err := exec.Command(z.zfsCommandName, commandArguments...).Output()
-	if exitError, ok := err.(*exec.ExitError); ok {
-		return nil, fmt.Errorf("failed to get filesystem list error: %s", exitError.Stderr)
-	}
 	if err != nil {
-		return nil, err
+		return nil, newCommandError(err, "failed to get filesystem list")
 	}
 	return output, nil
 }
 
+func (z *executorImpl) GetUserProperties(names []string) ([]Property, error) {
+	commandArguments := []string{"get", "-H", "-p", "-r", "-t", "filesystem", "-o", "name,property,value,source", strings.Join(names, ",")}
+	output, err := exec.Command(z.zfsCommandName, commandArguments...).Output()
+	if err != nil {
+		return nil, newCommandError(err, "failed to get properties: %s", strings.Join(names, ","))
+	}
+	return NewPropertiesFromOutput(output)
+}
+
+func (z *executorImpl) GetUsage(datasets []string) (map[string]Usage, error) {
+	commandArguments := append([]string{"get", "-H", "-p", "-o", "name,property,value", "used,usedbysnapshots"}, datasets...)
+	output, err := exec.Command(z.zfsCommandName, commandArguments...).Output()
+	if err != nil {
+		return nil, newCommandError(err, "failed to get space usage for datasets: %s", strings.Join(datasets, " "))
+	}
+	return NewUsageFromOutput(output)
+}
+
+func (z *executorImpl) GetPoolSpace(pool string) (PoolSpace, error) {
+	commandArguments := []string{"list", "-H", "-p", "-o", "free,size", pool}
+	output, err := exec.Command(z.zpoolCommandName, commandArguments...).Output()
+	if err != nil {
+		return PoolSpace{}, newCommandError(err, "failed to get space for pool: %s", pool)
+	}
+	return NewPoolSpaceFromOutput(output)
+}
+
 func (z *executorImpl) HasSnapshot(dataset string) (bool, error) {
 	argsStr := fmt.Sprintf("list -t snapshot -o name %s -H -d 1", dataset)
 	args := strings.Fields(argsStr)
 	output, err := exec.Command(z.zfsCommandName, args...).Output()
-	if exitError, ok := err.(*exec.ExitError); ok {
-		return false, fmt.Errorf("failed to get snapshot list to see if it has snapshots for dataset: %s error: %s", dataset, exitError.Stderr)
-	}
 	if err != nil {
-		return false, err
+		return false, newCommandError(err, "failed to get snapshot list to see if it has snapshots for dataset: %s", dataset)
 	}
 	return len(output) > 0, nil
 }
 
-func (z *executorImpl) HasHolds(snapshot string) (bool, error) {
-	argsStr := fmt.Sprintf("holds -H %s", snapshot)
-	args := strings.Fields(argsStr)
-	output, err := exec.Command(z.zfsCommandName, args...).Output()
-	if exitError, ok := err.(*exec.ExitError); ok {
-		return false, fmt.Errorf("failed to get snapshot holds to see if the snapshots has any holds: %s error: %s", snapshot, exitError.Stderr)
-	}
+func (z *executorImpl) GetGUID(snapshot string) (string, error) {
+	commandArguments := []string{"get", "-H", "-p", "-o", "value", "guid", snapshot}
+	output, err := exec.Command(z.zfsCommandName, commandArguments...).Output()
 	if err != nil {
-		return false, err
+		return "", newCommandError(err, "failed to get guid for snapshot: %s", snapshot)
 	}
-	return len(output) != 0, nil
+	return strings.TrimSpace(string(output)), nil
 }
 
 func (z *executorImpl) DestroySnapshot(snapshot string) ([]byte, error) {
 	output, err := exec.Command(z.zfsCommandName, "destroy", snapshot).Output()
-	if exitError, ok := err.(*exec.ExitError); ok {
-		return output, fmt.Errorf("failed to destroy snapshot: %s error: %s", snapshot, exitError.Stderr)
+	if err != nil {
+		return output, newCommandError(err, "failed to destroy snapshot: %s", snapshot)
 	}
+	return output, nil
+}
+
+func (z *executorImpl) DestroySnapshots(dataset string, names []string) ([]byte, error) {
+	snapshots := dataset + "@" + strings.Join(names, ",")
+	output, err := exec.Command(z.zfsCommandName, "destroy", snapshots).Output()
 	if err != nil {
-		return nil, err
+		return output, newCommandError(err, "failed to destroy snapshots: %s", snapshots)
 	}
 	return output, nil
 }
+
+func (z *executorImpl) DestroySnapshotRecursive(snapshot string) ([]byte, error) {
+	output, err := exec.Command(z.zfsCommandName, "destroy", "-R", snapshot).Output()
+	if err != nil {
+		return output, newCommandError(err, "failed to destroy snapshot and its clones: %s", snapshot)
+	}
+	return output, nil
+}
+
+func (z *executorImpl) EstimateReclaim(dataset string, names []string) (uint64, error) {
+	snapshots := dataset + "@" + strings.Join(names, ",")
+	output, err := exec.Command(z.zfsCommandName, "destroy", "-n", "-v", "-p", snapshots).Output()
+	if err != nil {
+		return 0, newCommandError(err, "failed to estimate space reclaimed by destroying snapshots: %s", snapshots)
+	}
+	return NewReclaimFromOutput(output)
+}